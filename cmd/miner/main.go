@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"runtime"
+	"time"
+
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/mining/stratum"
+)
+
+const RECONNECTINTERVAL = 5 * time.Second // Wait before reconnecting to the server
+
+var (
+	serverAddress string // Address of the node's stratum server (e.g., "127.0.0.1:3333")
+	worker        string // Name of the worker
+	threads       int    // Number of proof of work workers
+)
+
+func init() {
+	// Define command-line flags
+	flag.StringVar(&serverAddress, "stratum", "127.0.0.1:3333", "Address of the node's stratum server")
+	flag.StringVar(&worker, "worker", "worker1", "Name of the worker, used for the share accounting")
+	flag.IntVar(&threads, "threads", runtime.NumCPU(), "Number of proof of work workers")
+}
+
+func main() {
+	// Parse flags
+	flag.Parse()
+
+	// Mine until stopped, reconnecting when the connection is lost
+	for {
+		client := stratum.NewClient(serverAddress, worker, threads)
+		if err := client.Connect(); err != nil {
+			log.Println(err)
+		} else {
+			err := client.Run()
+			log.Printf("Disconnected from %s: %v\n", serverAddress, err)
+		}
+		time.Sleep(RECONNECTINTERVAL)
+	}
+}
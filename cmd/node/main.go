@@ -3,25 +3,43 @@ package main
 import (
 	"flag"
 	"log"
+	"net"
 	"os"
+	"path/filepath"
 
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/chaincfg"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/mining"
 	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/node"
 	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/wallet"
 )
 
 var (
+	network           string // Name of the network to join
 	port              string // Port to run the server
 	IPAddress         string // Node address (e.g., "127.0.0.1:8080")
 	bootstrapNodeAddr string // Address of the bootstrap node to join the network
 	walletFile        string // Filename for saving the wallet
+	dataDir           string // Directory for the block store
+	rpcPort           string // Port of the JSON-RPC server
+	threads           int    // Number of proof of work workers
+	stratumPort       string // Port of the stratum server
+	mine              bool   // Whether to mine blocks continuously
+	minerAddress      string // Address receiving the block rewards
 )
 
 func init() {
 	// Define command-line flags
-	flag.StringVar(&port, "port", "8080", "Port for the node to listen on")
+	flag.StringVar(&network, "network", "mainnet", "Network to join: 'mainnet', 'testnet' or 'regtest'")
+	flag.StringVar(&port, "port", "", "Port for the node to listen on (default: the network's default port)")
 	flag.StringVar(&IPAddress, "address", "", "IP address of the node (e.g., 127.0.0.1:8080)")
 	flag.StringVar(&bootstrapNodeAddr, "bootstrap", "", "Address of the bootstrap node to join the network (Optional)")
 	flag.StringVar(&walletFile, "wallet", "wallet.json", "Filename for saving the wallet")
+	flag.StringVar(&dataDir, "datadir", "", "Directory for the block store (default: data/<network>/<port>)")
+	flag.StringVar(&rpcPort, "rpcport", "", "Port for the JSON-RPC server on 127.0.0.1 (default: the network's RPC port, disabled if 0)")
+	flag.StringVar(&stratumPort, "stratumport", "", "Port for the stratum server for external miners (Optional, disabled if empty)")
+	flag.IntVar(&threads, "threads", 0, "Number of proof of work workers (default: the number of CPUs)")
+	flag.BoolVar(&mine, "mine", true, "Mine blocks continuously (networks mining on demand only do if set)")
+	flag.StringVar(&minerAddress, "mineraddress", "", "Address receiving the block rewards (default: the wallet's address)")
 }
 
 func main() {
@@ -41,6 +59,15 @@ func startNode() {
 		os.Exit(1)
 	}
 
+	// Look up the network parameters
+	params, err := chaincfg.GetParams(network)
+	if err != nil {
+		log.Fatalf("Error: %v\n", err)
+	}
+	if port == "" {
+		port = params.DefaultPort
+	}
+
 	// Load the wallet from file
 	w, err := wallet.LoadFromFile(walletFile)
 	if err != nil {
@@ -50,17 +77,70 @@ func startNode() {
 	// Get the address from the wallet
 	address := w.GetAddress()
 
+	// Use a per-network and per-port data directory by default
+	if dataDir == "" {
+		dataDir = filepath.Join("data", params.Name, port)
+	}
+
+	// Serve RPC on the loopback interface only, on the port the wallet
+	// expects by default
+	if rpcPort == "" {
+		rpcPort = params.RPCPort
+	}
+	rpcAddress := ""
+	if rpcPort != "0" {
+		rpcAddress = "127.0.0.1:" + rpcPort
+	}
+
+	// Serve stratum on the host of the node
+	stratumAddress := ""
+	if stratumPort != "" {
+		host, _, err := net.SplitHostPort(IPAddress)
+		if err != nil {
+			log.Fatalf("Error: invalid node address %s: %v\n", IPAddress, err)
+		}
+		stratumAddress = net.JoinHostPort(host, stratumPort)
+	}
+
 	// Create a new P2P node
-	node, err := node.NewNode(IPAddress, port, address)
+	node, err := node.NewNode(params, IPAddress, port, address, dataDir, rpcAddress, stratumAddress)
 	if err != nil {
 		log.Fatalf("Failed to create node: %v\n", err)
 	}
 	defer node.Close()
 
+	// Apply the mining settings
+	if err := configureMiner(node.Miner); err != nil {
+		log.Fatalf("Error: %v\n", err)
+	}
+
 	// Start the node
-	log.Printf("Starting node at %s...\n", IPAddress)
+	log.Printf("Starting node at %s on %s...\n", IPAddress, params.Name)
 	node.Run(bootstrapNodeAddr)
 
 	// Keep the server running
 	select {}
 }
+
+// configureMiner applies the mining flags, keeping the defaults of the
+// network for the flags not set
+func configureMiner(miner *mining.Miner) error {
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "mine" {
+			miner.SetEnabled(mine)
+		}
+	})
+
+	if threads > 0 {
+		if err := miner.SetThreads(threads); err != nil {
+			return err
+		}
+	}
+
+	if minerAddress != "" {
+		if err := miner.SetAddress(minerAddress); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -6,28 +6,33 @@ import (
 	"log"
 	"os"
 
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/amount"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/chaincfg"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/rpc"
 	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/wallet"
 )
 
 var (
-	IPAddress         string  // Address of the node (e.g., "127.0.0.1:8080")
-	bootstrapNodeAddr string  // Address of the bootstrap node to join the network
-	action            string  // Action to perform: createWallet, createTx
-	walletFile        string  // Filename for saving the wallet
-	recipient         string  // Recipient address for the transaction
-	amount            float64 // Amount to send in the transaction
-	fee               float64 // Transaction fee
+	network    string        // Name of the network of the node
+	rpcAddress string        // Address of the node's JSON-RPC server (e.g., "127.0.0.1:8332")
+	action     string        // Action to perform: createWallet, createTx, bumpFee
+	walletFile string        // Filename for saving the wallet
+	recipient  string        // Recipient address for the transaction
+	value      amount.Amount // Amount to send in the transaction
+	fee        amount.Amount // Transaction fee
+	txID       string        // ID of the pending transaction to replace
 )
 
 func init() {
 	// Define command-line flags
-	flag.StringVar(&IPAddress, "address", "", "IP address of the node (e.g., 127.0.0.1:8080")
-	flag.StringVar(&bootstrapNodeAddr, "bootstrap", "", "Address of the bootstrap node to join the network")
-	flag.StringVar(&action, "action", "create", "Action to perform: 'createWallet', 'createTx'")
+	flag.StringVar(&network, "network", "mainnet", "Network of the node: 'mainnet', 'testnet' or 'regtest'")
+	flag.StringVar(&rpcAddress, "rpc", "", "Address of the node's JSON-RPC server (default: 127.0.0.1 on the network's RPC port)")
+	flag.StringVar(&action, "action", "create", "Action to perform: 'createWallet', 'createTx', 'bumpFee'")
 	flag.StringVar(&walletFile, "wallet", "wallet.json", "Filename for saving the wallet")
-	flag.StringVar(&recipient, "recipient", "827c60efba743153785e6f790ddda0a1d5412608e3633c8808a44da10d7ce6c", "Recipient address for the transaction")
-	flag.Float64Var(&amount, "amount", 0.0, "Amount to send in the transaction")
-	flag.Float64Var(&fee, "fee", 0.0, "Transaction fee")
+	flag.StringVar(&recipient, "recipient", "", "Recipient address for the transaction: the public key of the recipient's wallet")
+	flag.Func("amount", "Amount to send in the transaction, in coins (e.g., 0.01)", parseAmountFlag(&value))
+	flag.Func("fee", "Transaction fee, in coins (e.g., 0.001)", parseAmountFlag(&fee))
+	flag.StringVar(&txID, "txid", "", "ID of the pending transaction whose fee to bump")
 }
 
 func main() {
@@ -39,8 +44,10 @@ func main() {
 		createWallet()
 	case "createTx":
 		createTransaction()
+	case "bumpFee":
+		bumpFee()
 	default:
-		fmt.Println("Invalid action. Use 'createWallet'")
+		fmt.Println("Invalid action. Use 'createWallet', 'createTx' or 'bumpFee'")
 		flag.Usage()
 		os.Exit(1)
 	}
@@ -67,16 +74,78 @@ func createTransaction() {
 		log.Fatalf("Failed to load wallet: %v\n", err)
 	}
 
+	client := newClient()
+
+	// Fetch the unspent outputs of the wallet
+	utxos, err := w.FetchUTXOs(client)
+	if err != nil {
+		log.Fatalf("Failed to fetch UTXOs: %v\n", err)
+	}
+
 	// Create a new transaction
-	tx, err := w.CreateTransaction(recipient, amount, fee)
+	tx, err := w.CreateTransaction(utxos, recipient, value, fee)
 	if err != nil {
 		log.Fatalf("Failed to create transaction: %v\n", err)
 	}
 	fmt.Printf("Transaction created!\nID: %s\n", tx.TransactionID)
 
 	// Send the transaction to the network
-	err = w.SendTransaction(tx, IPAddress, bootstrapNodeAddr)
+	err = w.SendTransaction(client, tx)
 	if err != nil {
 		log.Fatalf("Failed to send transaction: %v\n", err)
 	}
+	fmt.Println("Transaction sent!")
+}
+
+func bumpFee() {
+	// Load the wallet from file
+	w, err := wallet.LoadFromFile(walletFile)
+	if err != nil {
+		log.Fatalf("Failed to load wallet: %v\n", err)
+	}
+	client := newClient()
+
+	// Fetch the pending transaction
+	tx, err := w.FetchPendingTransaction(client, txID)
+	if err != nil {
+		log.Fatalf("Failed to fetch transaction: %v\n", err)
+	}
+
+	// Re-sign the transaction with the new fee
+	replacement, err := w.BumpFee(tx, fee)
+	if err != nil {
+		log.Fatalf("Failed to bump fee: %v\n", err)
+	}
+	fmt.Printf("Replacement created!\nID: %s\n", replacement.TransactionID)
+
+	// Send the replacement to the network
+	err = w.SendTransaction(client, replacement)
+	if err != nil {
+		log.Fatalf("Failed to send transaction: %v\n", err)
+	}
+	fmt.Println("Replacement sent!")
+}
+
+// newClient connects to the node, on the network's RPC port by default
+func newClient() *rpc.Client {
+	if rpcAddress == "" {
+		params, err := chaincfg.GetParams(network)
+		if err != nil {
+			log.Fatalf("Error: %v\n", err)
+		}
+		rpcAddress = "127.0.0.1:" + params.RPCPort
+	}
+	return rpc.NewClient(rpcAddress)
+}
+
+// parseAmountFlag returns a flag parser reading an amount given in coins
+func parseAmountFlag(target *amount.Amount) func(string) error {
+	return func(s string) error {
+		a, err := amount.ParseAmount(s)
+		if err != nil {
+			return err
+		}
+		*target = a
+		return nil
+	}
 }
@@ -0,0 +1,93 @@
+package amount
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	DECIMALS  = 8                     // Number of decimals of a coin
+	COIN      = Amount(100_000_000)   // Base units per coin
+	MAXAMOUNT = Amount(math.MaxInt64) // Largest representable amount
+	MINAMOUNT = Amount(math.MinInt64) // Smallest representable amount
+)
+
+// Amount is a quantity of money counted in integer base units.
+// It is encoded in JSON as a plain integer of base units.
+type Amount int64
+
+// Add returns a + b, or an error if the sum overflows
+func (a Amount) Add(b Amount) (Amount, error) {
+	if (b > 0 && a > MAXAMOUNT-b) || (b < 0 && a < MINAMOUNT-b) {
+		return 0, fmt.Errorf("amount overflow: %d + %d", a, b)
+	}
+	return a + b, nil
+}
+
+// Sub returns a - b, or an error if the difference overflows
+func (a Amount) Sub(b Amount) (Amount, error) {
+	if (b < 0 && a > MAXAMOUNT+b) || (b > 0 && a < MINAMOUNT+b) {
+		return 0, fmt.Errorf("amount overflow: %d - %d", a, b)
+	}
+	return a - b, nil
+}
+
+// Sum returns the sum of the amounts, or an error if it overflows
+func Sum(amounts ...Amount) (Amount, error) {
+	total := Amount(0)
+	for _, a := range amounts {
+		var err error
+		if total, err = total.Add(a); err != nil {
+			return 0, err
+		}
+	}
+	return total, nil
+}
+
+// String formats the amount in display units (e.g. "1.50000000")
+func (a Amount) String() string {
+	sign := ""
+	units := uint64(a)
+	if a < 0 {
+		sign = "-"
+		units = uint64(-(a + 1)) + 1
+	}
+	return fmt.Sprintf("%s%d.%08d", sign, units/uint64(COIN), units%uint64(COIN))
+}
+
+// ParseAmount parses a non-negative amount given in display units (e.g. "1.5")
+// without going through floating point
+func ParseAmount(s string) (Amount, error) {
+	whole, frac, _ := strings.Cut(strings.TrimSpace(s), ".")
+	if whole == "" && frac == "" {
+		return 0, fmt.Errorf("invalid amount: %q", s)
+	}
+	if len(frac) > DECIMALS {
+		return 0, fmt.Errorf("invalid amount %q: more than %d decimals", s, DECIMALS)
+	}
+
+	// Parse the whole coins
+	coins := uint64(0)
+	if whole != "" {
+		var err error
+		if coins, err = strconv.ParseUint(whole, 10, 63); err != nil {
+			return 0, fmt.Errorf("invalid amount: %q", s)
+		}
+	}
+
+	// Parse the fraction, padded to base units
+	units := uint64(0)
+	if frac != "" {
+		var err error
+		if units, err = strconv.ParseUint(frac+strings.Repeat("0", DECIMALS-len(frac)), 10, 63); err != nil {
+			return 0, fmt.Errorf("invalid amount: %q", s)
+		}
+	}
+
+	if coins > uint64(MAXAMOUNT/COIN) {
+		return 0, fmt.Errorf("amount overflow: %q", s)
+	}
+	return (Amount(coins) * COIN).Add(Amount(units))
+}
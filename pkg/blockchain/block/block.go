@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/amount"
 	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/transaction"
 	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/utils"
 )
@@ -13,15 +14,14 @@ type Block struct {
 	PrevHash     string                     `json:"prev_hash"`    // Hash of the previous block
 	MerkleRoot   string                     `json:"merkle_root"`  // Merkle root of the transactions
 	Timestamp    int64                      `json:"timestamp"`    // Unix timestamp
-	Nonce        int                        `json:"nonce"`        // Proof of work
-	Difficulty   int                        `json:"difficulty"`   // Difficulty of the block
+	Nonce        uint32                     `json:"nonce"`        // Proof of work
+	Bits         uint32                     `json:"bits"`         // Compact encoding of the PoW target
 	Transactions []*transaction.Transaction `json:"transactions"` // List of transactions
 }
 
 // Hash returns the hash of the block
 func (b *Block) Hash() string {
-	data := fmt.Sprintf("%s%s%d%d%d", b.PrevHash, b.MerkleRoot, b.Timestamp, b.Nonce, b.Difficulty)
-	return utils.Hash(data)
+	return b.Header().Hash()
 }
 
 // GenerateBlockID generates a unique ID for the block
@@ -29,10 +29,10 @@ func (b *Block) GenerateBlockID() string {
 	return b.Hash()
 }
 
-// NewBlock creates a new block with the given previous hash and transactions
-func NewBlock(prevHash string, transactions []*transaction.Transaction, miner string, reward float64, difficulty int) *Block {
+// NewBlock creates a new block at the given height with the given previous hash and transactions
+func NewBlock(prevHash string, height int, transactions []*transaction.Transaction, miner string, reward amount.Amount, bits uint32, timestamp int64) *Block {
 	// Create a coinbase transaction to reward the miner
-	coinbaseTx := transaction.NewCoinbaseTransaction(miner, reward)
+	coinbaseTx := transaction.NewCoinbaseTransaction(miner, reward, height)
 	transactions = append([]*transaction.Transaction{coinbaseTx}, transactions...)
 
 	// Compute the Merkle root
@@ -41,18 +41,52 @@ func NewBlock(prevHash string, transactions []*transaction.Transaction, miner st
 	block := &Block{
 		PrevHash:     prevHash,
 		MerkleRoot:   merkleRoot,
-		Timestamp:    0,
+		Timestamp:    timestamp,
 		Nonce:        0,
-		Difficulty:   difficulty,
+		Bits:         bits,
 		Transactions: transactions,
 	}
 	block.BlockID = block.GenerateBlockID()
 	return block
 }
 
-// NewGenesisBlock creates the first block in the blockchain
-func NewGenesisBlock() *Block {
-	return NewBlock("", nil, "", 0, 0)
+// NewGenesisBlock creates the first block in the blockchain. Each network
+// pins its own timestamp, so that every node of a network derives the same
+// genesis block and nodes of different networks do not.
+func NewGenesisBlock(timestamp int64) *Block {
+	coinbaseTx := transaction.NewCoinbaseTransaction("", 0, 0)
+	coinbaseTx.Timestamp = timestamp
+	coinbaseTx.TransactionID = coinbaseTx.GenerateTransactionID()
+	transactions := []*transaction.Transaction{coinbaseTx}
+
+	block := &Block{
+		PrevHash:     "",
+		MerkleRoot:   ComputeMerkleRoot(transactions),
+		Timestamp:    timestamp,
+		Nonce:        0,
+		Bits:         0,
+		Transactions: transactions,
+	}
+	block.BlockID = block.GenerateBlockID()
+	return block
+}
+
+// SetExtraNonce sets the extra nonce of the coinbase, which gives the proof
+// of work a new Merkle root to search once the nonces are exhausted
+func (b *Block) SetExtraNonce(extraNonce uint64) {
+	coinbaseTx := b.Transactions[0]
+	coinbaseTx.ExtraNonce = extraNonce
+	coinbaseTx.TransactionID = coinbaseTx.GenerateTransactionID()
+	b.MerkleRoot = ComputeMerkleRoot(b.Transactions)
+}
+
+// Size returns the size of the serialized block in bytes
+func (b *Block) Size() int {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return 0
+	}
+	return len(data)
 }
 
 // Serialize serializes the block to a JSON string
@@ -80,10 +114,10 @@ func ComputeMerkleRoot(transactions []*transaction.Transaction) string {
 		return ""
 	}
 
-	// Step 1: Get the hash of each transaction
+	// Step 1: Get the ID of each transaction, which commits to its signature
 	var transactionHashes []string
 	for _, tx := range transactions {
-		transactionHashes = append(transactionHashes, tx.Hash())
+		transactionHashes = append(transactionHashes, tx.TransactionID)
 	}
 
 	// Step 2: Compute the Merkle Root from the transaction hashes
@@ -109,3 +143,40 @@ func ComputeMerkleRoot(transactions []*transaction.Transaction) string {
 	// The last remaining hash is the Merkle Root
 	return transactionHashes[0]
 }
+
+// ComputeMerkleBranch computes the hashes the coinbase is paired with on its
+// way up to the Merkle root, so that the root can be recomputed from a
+// modified coinbase alone
+func ComputeMerkleBranch(transactions []*transaction.Transaction) []string {
+	var transactionHashes []string
+	for _, tx := range transactions {
+		transactionHashes = append(transactionHashes, tx.TransactionID)
+	}
+
+	// The coinbase always comes first, so its pair is the second hash of each level
+	branch := make([]string, 0)
+	for len(transactionHashes) > 1 {
+		branch = append(branch, transactionHashes[1])
+
+		var newLevel []string
+		for i := 0; i < len(transactionHashes); i += 2 {
+			if i+1 < len(transactionHashes) {
+				newLevel = append(newLevel, utils.HashPair(transactionHashes[i], transactionHashes[i+1]))
+			} else {
+				newLevel = append(newLevel, utils.HashPair(transactionHashes[i], transactionHashes[i]))
+			}
+		}
+		transactionHashes = newLevel
+	}
+	return branch
+}
+
+// ComputeMerkleRootFromBranch computes the Merkle root from the ID of the
+// coinbase and its Merkle branch
+func ComputeMerkleRootFromBranch(coinbaseID string, branch []string) string {
+	root := coinbaseID
+	for _, hash := range branch {
+		root = utils.HashPair(root, hash)
+	}
+	return root
+}
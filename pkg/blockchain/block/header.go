@@ -0,0 +1,127 @@
+package block
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// Layout of the binary header hashed by the proof of work
+const (
+	HEADERPREVHASHOFFSET   = 0  // Offset of the previous hash (32 bytes)
+	HEADERMERKLEROOTOFFSET = 32 // Offset of the Merkle root (32 bytes)
+	HEADERTIMESTAMPOFFSET  = 64 // Offset of the timestamp (int64, little endian)
+	HEADERBITSOFFSET       = 72 // Offset of the compact target (uint32, little endian)
+	HEADERNONCEOFFSET      = 76 // Offset of the nonce (uint32, little endian)
+	HEADERSIZE             = 80 // Size of the binary header in bytes
+)
+
+type Header struct {
+	BlockID    string `json:"block_id"`    // Hash of the block
+	PrevHash   string `json:"prev_hash"`   // Hash of the previous block
+	MerkleRoot string `json:"merkle_root"` // Merkle root of the transactions
+	Timestamp  int64  `json:"timestamp"`   // Unix timestamp
+	Nonce      uint32 `json:"nonce"`       // Proof of work
+	Bits       uint32 `json:"bits"`        // Compact encoding of the PoW target
+}
+
+// Header returns the header of the block
+func (b *Block) Header() *Header {
+	return &Header{
+		BlockID:    b.BlockID,
+		PrevHash:   b.PrevHash,
+		MerkleRoot: b.MerkleRoot,
+		Timestamp:  b.Timestamp,
+		Nonce:      b.Nonce,
+		Bits:       b.Bits,
+	}
+}
+
+// Bytes returns the binary header hashed by the proof of work. A hash that
+// is not 32 hex-encoded bytes, such as the empty previous hash of the genesis
+// block, is encoded as zeros.
+func (h *Header) Bytes() []byte {
+	buf := make([]byte, HEADERSIZE)
+	putHash(buf[HEADERPREVHASHOFFSET:HEADERMERKLEROOTOFFSET], h.PrevHash)
+	putHash(buf[HEADERMERKLEROOTOFFSET:HEADERTIMESTAMPOFFSET], h.MerkleRoot)
+	PutHeaderTimestamp(buf, h.Timestamp)
+	binary.LittleEndian.PutUint32(buf[HEADERBITSOFFSET:], h.Bits)
+	PutHeaderNonce(buf, h.Nonce)
+	return buf
+}
+
+// PutHeaderTimestamp writes the timestamp into a binary header
+func PutHeaderTimestamp(buf []byte, timestamp int64) {
+	binary.LittleEndian.PutUint64(buf[HEADERTIMESTAMPOFFSET:], uint64(timestamp))
+}
+
+// PutHeaderNonce writes the nonce into a binary header
+func PutHeaderNonce(buf []byte, nonce uint32) {
+	binary.LittleEndian.PutUint32(buf[HEADERNONCEOFFSET:], nonce)
+}
+
+// putHash writes a hex-encoded 32-byte hash into the buffer
+func putHash(buf []byte, hash string) {
+	if decoded, err := hex.DecodeString(hash); err == nil && len(decoded) == sha256.Size {
+		copy(buf, decoded)
+	}
+}
+
+// isHash checks if the string is a hex-encoded 32-byte hash
+func isHash(hash string) bool {
+	decoded, err := hex.DecodeString(hash)
+	return err == nil && len(decoded) == sha256.Size
+}
+
+// Hash returns the hash of the binary header
+func (h *Header) Hash() string {
+	hash := sha256.Sum256(h.Bytes())
+	return hex.EncodeToString(hash[:])
+}
+
+// Validate validates the header ID and its proof of work against the
+// easiest target allowed by the network
+func (h *Header) Validate(powLimitBits uint32) error {
+	// Check the encoding of the hashes committed to by the header
+	if !isHash(h.PrevHash) || !isHash(h.MerkleRoot) {
+		return fmt.Errorf("invalid header hashes")
+	}
+
+	if h.BlockID != h.Hash() {
+		return fmt.Errorf("invalid block ID")
+	}
+
+	// Check the target range
+	target := CompactToBig(h.Bits)
+	if target.Sign() <= 0 || target.Cmp(CompactToBig(powLimitBits)) > 0 {
+		return fmt.Errorf("target out of range: %08x", h.Bits)
+	}
+
+	// Check the block hash against the target
+	if !MeetsTarget(h.BlockID, h.Bits) {
+		return fmt.Errorf("block hash does not meet the target %08x", h.Bits)
+	}
+
+	return nil
+}
+
+// SerializeHeaders serializes a list of headers into a string
+func SerializeHeaders(headers []*Header) (string, error) {
+	data, err := json.Marshal(headers)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize headers: %v", err)
+	}
+	return string(data), nil
+}
+
+// DeserializeHeaders deserializes a list of headers from a string
+func DeserializeHeaders(data string) ([]*Header, error) {
+	var headers []*Header
+	err := json.Unmarshal([]byte(data), &headers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to deserialize headers: %v", err)
+	}
+	return headers, nil
+}
@@ -2,6 +2,20 @@ package block
 
 import "github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/message"
 
+// NewBlockMessage creates a BLOCK message carrying a single requested block
+func NewBlockMessage(b *Block, sender, receipient string) *message.Message {
+	blockData, err := b.Serialize()
+	if err != nil {
+		return nil
+	}
+	return message.NewMessage(
+		message.BLOCK,
+		sender,
+		receipient,
+		blockData,
+	)
+}
+
 func NewMinedBlockMessage(minedBlock *Block, sender string) *message.Message {
 	blockData, err := minedBlock.Serialize()
 	if err != nil {
@@ -0,0 +1,89 @@
+package block
+
+import (
+	"encoding/hex"
+	"math/big"
+)
+
+var oneLsh256 = new(big.Int).Lsh(big.NewInt(1), 256) // 2^256
+
+// CompactToBig converts a compact "bits" representation to a 256-bit target.
+// The compact form packs a 1-byte exponent and a 3-byte mantissa, as in Bitcoin's nBits.
+func CompactToBig(compact uint32) *big.Int {
+	mantissa := compact & 0x007fffff
+	isNegative := compact&0x00800000 != 0
+	exponent := uint(compact >> 24)
+
+	var target *big.Int
+	if exponent <= 3 {
+		mantissa >>= 8 * (3 - exponent)
+		target = big.NewInt(int64(mantissa))
+	} else {
+		target = big.NewInt(int64(mantissa))
+		target.Lsh(target, 8*(exponent-3))
+	}
+
+	if isNegative {
+		target.Neg(target)
+	}
+	return target
+}
+
+// BigToCompact converts a 256-bit target to its compact "bits" representation
+func BigToCompact(target *big.Int) uint32 {
+	if target.Sign() == 0 {
+		return 0
+	}
+
+	var mantissa uint32
+	exponent := uint(len(target.Bytes()))
+	if exponent <= 3 {
+		mantissa = uint32(target.Bits()[0])
+		mantissa <<= 8 * (3 - exponent)
+	} else {
+		tmp := new(big.Int).Set(target)
+		mantissa = uint32(tmp.Rsh(tmp, 8*(exponent-3)).Bits()[0])
+	}
+
+	// Keep the sign bit clear by moving one byte into the exponent
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+
+	compact := uint32(exponent<<24) | mantissa
+	if target.Sign() < 0 {
+		compact |= 0x00800000
+	}
+	return compact
+}
+
+// CalcWork returns the expected number of hashes needed to meet the target
+// encoded by bits, i.e. 2^256 / (target + 1)
+func CalcWork(bits uint32) *big.Int {
+	target := CompactToBig(bits)
+	if target.Sign() <= 0 {
+		return big.NewInt(0)
+	}
+
+	denominator := new(big.Int).Add(target, big.NewInt(1))
+	return new(big.Int).Div(oneLsh256, denominator)
+}
+
+// HashToBig interprets a hex-encoded hash as a 256-bit integer
+func HashToBig(hash string) *big.Int {
+	hashBytes, err := hex.DecodeString(hash)
+	if err != nil {
+		return nil
+	}
+	return new(big.Int).SetBytes(hashBytes)
+}
+
+// MeetsTarget checks if the hash is at or below the target encoded by bits
+func MeetsTarget(hash string, bits uint32) bool {
+	hashNum := HashToBig(hash)
+	if hashNum == nil {
+		return false
+	}
+	return hashNum.Cmp(CompactToBig(bits)) <= 0
+}
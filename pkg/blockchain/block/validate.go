@@ -2,18 +2,30 @@ package block
 
 import (
 	"fmt"
-	"strings"
+
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/chaincfg"
 )
 
-// Validate validates the block
-func (b *Block) Validate() error {
+// Validate validates the block against the consensus rules of the network
+func (b *Block) Validate(params *chaincfg.Params) error {
 	// Validate the block ID
 	if err := b.validateBlockID(); err != nil {
 		return err
 	}
 
-	// Validate the difficulty
-	if err := b.validateDifficulty(); err != nil {
+	// Validate the proof of work
+	if err := b.validateProofOfWork(params.PowLimitBits); err != nil {
+		return err
+	}
+
+	return b.ValidateTemplate(params)
+}
+
+// ValidateTemplate validates a block whose proof of work is not performed
+// yet: everything but the block ID and the proof of work
+func (b *Block) ValidateTemplate(params *chaincfg.Params) error {
+	// Validate the size and the cost of the block
+	if err := b.validateLimits(params); err != nil {
 		return err
 	}
 
@@ -39,24 +51,48 @@ func (b *Block) validateBlockID() error {
 	return nil
 }
 
-// ValidateDifficulty validates the difficulty
-func (b *Block) validateDifficulty() error {
-	prefix := strings.Repeat("0", b.Difficulty)
-	if !strings.HasPrefix(b.BlockID, prefix) {
-		return fmt.Errorf("invalid difficulty")
+// validateProofOfWork validates that the block hash meets the target
+func (b *Block) validateProofOfWork(powLimitBits uint32) error {
+	return b.Header().Validate(powLimitBits)
+}
+
+// validateLimits validates the size of the block and the size and the
+// sigop cost of its transactions
+func (b *Block) validateLimits(params *chaincfg.Params) error {
+	if size := b.Size(); size > params.MaxBlockSize {
+		return fmt.Errorf("block size %d exceeds the limit of %d bytes", size, params.MaxBlockSize)
+	}
+
+	sigOpCost := 0
+	for _, tx := range b.Transactions {
+		if err := tx.ValidateLimits(params.MaxTxSize, params.MaxBlockSigOpCost); err != nil {
+			return fmt.Errorf("invalid transaction %s: %v", tx.TransactionID, err)
+		}
+		sigOpCost += tx.SigOpCost()
+	}
+	if sigOpCost > params.MaxBlockSigOpCost {
+		return fmt.Errorf("block sigop cost %d exceeds the limit of %d", sigOpCost, params.MaxBlockSigOpCost)
 	}
 
 	return nil
 }
 
-// ValidateTransactions validates the transactions
+// ValidateTransactions validates that the block starts with its only
+// coinbase, followed by valid regular transactions
 func (b *Block) validateTransactions() error {
-	for i, tx := range b.Transactions {
-		// Skip if the transaction is coinbase
-		if i != 0 {
-			if err := tx.Validate(); err != nil {
-				return fmt.Errorf("invalid transaction: %v", err)
-			}
+	if len(b.Transactions) == 0 {
+		return fmt.Errorf("block has no coinbase")
+	}
+
+	// Validate the coinbase
+	if err := b.Transactions[0].ValidateCoinbase(); err != nil {
+		return fmt.Errorf("invalid coinbase: %v", err)
+	}
+
+	// Validate the other transactions, which may not use the coinbase sender
+	for _, tx := range b.Transactions[1:] {
+		if err := tx.Validate(); err != nil {
+			return fmt.Errorf("invalid transaction: %v", err)
 		}
 	}
 
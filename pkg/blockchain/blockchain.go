@@ -4,34 +4,54 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"math/big"
 	"sync"
 	"time"
 
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/amount"
 	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/block"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/store"
 	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/transaction"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/utxo"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/chaincfg"
 	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/mining/mempool"
 )
 
 type Blockchain struct {
-	BaseReward    float64
-	Blocks        []*block.Block   `json:"blocks"`        // Blocks in the blockchain
-	mutex         *sync.RWMutex    `json:"-"`             // Mutex to protect the blockchain
-	CumulativePoW int              `json:"cumulativePoW"` // Tracks total proof-of-work (sum of difficulties)
-	Mempool       *mempool.Mempool `json:"-"`             // Reference to the mempool
-	StopRunning   chan bool        `json:"-"`             // Channel to stop the blockchain
+	Params        *chaincfg.Params     `json:"-"`             // Parameters of the network
+	Blocks        []*block.Block       `json:"blocks"`        // Blocks of the main chain
+	mutex         *sync.RWMutex        `json:"-"`             // Mutex to protect the blockchain
+	CumulativePoW *big.Int             `json:"cumulativePoW"` // Tracks total proof-of-work (sum of expected work)
+	UTXOSet       *utxo.UTXOSet        `json:"-"`             // Unspent transaction outputs of the chain
+	TxIndex       map[string]int       `json:"-"`             // TransactionID -> Height of the block confirming it
+	Undo          map[string]BlockUndo `json:"-"`             // BlockID -> Undo data of the main chain blocks
+	Index         *BlockIndex          `json:"-"`             // Tree of every known block
+	TimeSource    *MedianTimeSource    `json:"-"`             // Network-adjusted clock
+	Store         *store.BlockStore    `json:"-"`             // On-disk block store (nil for in-memory chains)
+	Mempool       *mempool.Mempool     `json:"-"`             // Reference to the mempool
+	Events        *EventBus            `json:"-"`             // Bus of the main chain events
+	StopRunning   chan bool            `json:"-"`             // Channel to stop the blockchain
 }
 
-// NewBlockchain creates a new blockchain with the genesis block
-func NewBlockchain(mempool *mempool.Mempool) *Blockchain {
-	genesisBlock := block.NewGenesisBlock()
-	return &Blockchain{
-		BaseReward:    1000.0,
+// NewBlockchain creates a new blockchain with the genesis block of the network
+func NewBlockchain(params *chaincfg.Params, mempool *mempool.Mempool) *Blockchain {
+	genesisBlock := block.NewGenesisBlock(params.GenesisTimestamp)
+	bc := &Blockchain{
+		Params:        params,
 		Blocks:        []*block.Block{genesisBlock},
 		mutex:         &sync.RWMutex{},
-		CumulativePoW: genesisBlock.Difficulty,
+		CumulativePoW: block.CalcWork(genesisBlock.Bits),
+		UTXOSet:       utxo.NewUTXOSet(),
+		TxIndex:       make(map[string]int),
+		Undo:          make(map[string]BlockUndo),
+		Index:         NewBlockIndex(genesisBlock),
+		TimeSource:    NewMedianTimeSource(),
 		Mempool:       mempool,
+		Events:        NewEventBus(),
 		StopRunning:   make(chan bool, 1),
 	}
+	bc.connectBlock(genesisBlock, 0)
+	return bc
 }
 
 // Run starts the blockchain loop
@@ -50,38 +70,35 @@ func (bc *Blockchain) Run() {
 // Close stops the blockchain
 func (bc *Blockchain) Close() {
 	bc.StopRunning <- true
+
+	// Close the block store
+	if bc.Store != nil {
+		bc.Store.Close()
+	}
 }
 
 // NewBlock creates a new block with the given transactions
-func (bc *Blockchain) NewBlock(transactions []*transaction.Transaction, miner string) *block.Block {
+func (bc *Blockchain) NewBlock(transactions []*transaction.Transaction, miner string) (*block.Block, error) {
 	bc.mutex.RLock()
 	defer bc.mutex.RUnlock()
 
 	prevHash := bc.GetLatestBlock().BlockID
-	reward := bc.CalculateReward(transactions)
-	difficulty := bc.CalculateDifficulty()
-	return block.NewBlock(prevHash, transactions, miner, reward, difficulty)
+	reward, err := bc.CalculateReward(len(bc.Blocks), transactions)
+	if err != nil {
+		return nil, err
+	}
+	bits := bc.CalculateBits()
+	timestamp := bc.nextBlockTimestamp()
+	return block.NewBlock(prevHash, len(bc.Blocks), transactions, miner, reward, bits, timestamp), nil
 }
 
-// AddBlock adds a new block to the blockchain
-func (bc *Blockchain) AddBlock(block *block.Block) error {
-	bc.mutex.Lock()
-	defer bc.mutex.Unlock()
-
-	// Validate the block
-	if err := bc.ValidateNewBlock(block); err != nil {
+// AddBlock adds a new block to the block index; it becomes the tip if it
+// extends the branch with the most work
+func (bc *Blockchain) AddBlock(b *block.Block) error {
+	if _, err := bc.ProcessBlock(b); err != nil {
 		log.Println("Block validation failed:", err)
 		return err
 	}
-
-	bc.Blocks = append(bc.Blocks, block)
-	bc.CumulativePoW += block.Difficulty
-
-	// Remove transactions in the block from the mempool
-	for _, tx := range block.Transactions {
-		bc.Mempool.RemoveTransaction(tx.TransactionID)
-	}
-
 	return nil
 }
 
@@ -90,46 +107,35 @@ func (bc *Blockchain) GetLatestBlock() *block.Block {
 	return bc.Blocks[len(bc.Blocks)-1]
 }
 
-// CalculateReward calculates the reward for the miner
-func (bc *Blockchain) CalculateReward(transactions []*transaction.Transaction) float64 {
-	total_fee := 0.0
-	for _, tx := range transactions {
-		total_fee += tx.Fee
+// CalculateReward calculates the reward for the miner of the block at the
+// given height: the scheduled subsidy plus the fees of the transactions
+func (bc *Blockchain) CalculateReward(height int, transactions []*transaction.Transaction) (amount.Amount, error) {
+	fees, err := totalFees(transactions)
+	if err != nil {
+		return 0, fmt.Errorf("invalid reward: %v", err)
 	}
 
-	return bc.BaseReward + total_fee
+	reward, err := bc.Params.Subsidy.BlockSubsidy(height).Add(fees)
+	if err != nil {
+		return 0, fmt.Errorf("invalid reward: %v", err)
+	}
+	return reward, nil
 }
 
-// CalculateDifficulty calculates the difficulty for the miner
-func (bc *Blockchain) CalculateDifficulty() int {
-	return 5
+// CalculateBits calculates the compact PoW target for the miner
+func (bc *Blockchain) CalculateBits() uint32 {
+	return CalculateNextBits(bc.Params, bc.Blocks)
 }
 
 // CalculateCumulativePoW calculates the cumulative proof-of-work
-func (bc *Blockchain) CalculateCumulativePoW() int {
-	cumulativePoW := 0
+func (bc *Blockchain) CalculateCumulativePoW() *big.Int {
+	cumulativePoW := big.NewInt(0)
 	for _, b := range bc.Blocks {
-		cumulativePoW += b.Difficulty
+		cumulativePoW.Add(cumulativePoW, block.CalcWork(b.Bits))
 	}
 	return cumulativePoW
 }
 
-// calculateUTXOs calculates the UTXOs for an address
-func (bc *Blockchain) calculateUTXOs(address string) float64 {
-	utxos := 0.0
-	for _, b := range bc.Blocks {
-		for _, tx := range b.Transactions {
-			if tx.Sender == address {
-				utxos -= tx.Amount + tx.Fee
-			}
-			if tx.Recipient == address {
-				utxos += tx.Amount
-			}
-		}
-	}
-	return utxos
-}
-
 // Serialize serializes the blockchain to a JSON string
 func (bc *Blockchain) Serialize() (string, error) {
 	bc.mutex.RLock()
@@ -149,6 +155,9 @@ func DeserializeBlockchain(data string) (*Blockchain, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to deserialize blockchain: %v", err)
 	}
+	if len(bc.Blocks) == 0 {
+		return nil, fmt.Errorf("failed to deserialize blockchain: no blocks")
+	}
 	return &bc, nil
 }
 
@@ -170,9 +179,13 @@ func (bc *Blockchain) Print() {
 		for _, tx := range blk.Transactions {
 			fmt.Printf("    ├── ID: %s\n", tx.TransactionID)
 			fmt.Printf("    ├── Sender: %s\n", tx.Sender)
-			fmt.Printf("    ├── Recipient: %s\n", tx.Recipient)
-			fmt.Printf("    ├── Amount: %.2f\n", tx.Amount)
-			fmt.Printf("    ├── Fee: %.2f\n", tx.Fee)
+			for _, input := range tx.Inputs {
+				fmt.Printf("    ├── Input: %s:%d\n", input.TxID, input.OutputIndex)
+			}
+			for _, output := range tx.Outputs {
+				fmt.Printf("    ├── Output: %s -> %s\n", output.Value, output.Address)
+			}
+			fmt.Printf("    ├── Fee: %s\n", tx.Fee)
 			fmt.Printf("    └── Signature: %s\n", tx.Signature)
 		}
 		fmt.Println()
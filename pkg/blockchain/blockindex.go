@@ -0,0 +1,106 @@
+package blockchain
+
+import (
+	"math/big"
+
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/block"
+)
+
+const MAXORPHANBLOCKS = 100 // Maximum number of blocks held while waiting for their parent
+
+type BlockNode struct {
+	BlockID       string       // ID of the block
+	Parent        *BlockNode   // Node of the previous block, nil for the genesis block
+	Height        int          // Height of the block
+	CumulativePoW *big.Int     // Total work of the chain ending at the block
+	Block         *block.Block // The block
+	Invalid       bool         // Whether the block or one of its ancestors failed validation
+}
+
+type BlockIndex struct {
+	Nodes   map[string]*BlockNode   // BlockID -> Node of every accepted block, on any branch
+	Orphans map[string]*block.Block // BlockID -> Block waiting for its parent
+	Best    *BlockNode              // Valid node with the most cumulative work
+}
+
+// NewBlockIndex creates a block tree rooted at the genesis block
+func NewBlockIndex(genesisBlock *block.Block) *BlockIndex {
+	root := &BlockNode{
+		BlockID:       genesisBlock.BlockID,
+		Height:        0,
+		CumulativePoW: block.CalcWork(genesisBlock.Bits),
+		Block:         genesisBlock,
+	}
+
+	return &BlockIndex{
+		Nodes:   map[string]*BlockNode{root.BlockID: root},
+		Orphans: make(map[string]*block.Block),
+		Best:    root,
+	}
+}
+
+// AddNode adds a block on top of its parent node and returns its node
+func (idx *BlockIndex) AddNode(b *block.Block, parent *BlockNode) *BlockNode {
+	node := &BlockNode{
+		BlockID:       b.BlockID,
+		Parent:        parent,
+		Height:        parent.Height + 1,
+		CumulativePoW: new(big.Int).Add(parent.CumulativePoW, block.CalcWork(b.Bits)),
+		Block:         b,
+		Invalid:       parent.Invalid,
+	}
+	idx.Nodes[node.BlockID] = node
+
+	// The first node seen with the most work wins ties
+	if !node.Invalid && node.CumulativePoW.Cmp(idx.Best.CumulativePoW) > 0 {
+		idx.Best = node
+	}
+	return node
+}
+
+// Invalidate marks a node and all its descendants as invalid and picks the
+// best valid node again
+func (idx *BlockIndex) Invalidate(invalid *BlockNode) {
+	invalid.Invalid = true
+	for _, node := range idx.Nodes {
+		for ancestor := node.Parent; ancestor != nil && ancestor.Height >= invalid.Height; ancestor = ancestor.Parent {
+			if ancestor == invalid {
+				node.Invalid = true
+				break
+			}
+		}
+	}
+
+	// Pick the best valid node; the genesis block is always valid
+	var best *BlockNode
+	for _, node := range idx.Nodes {
+		if !node.Invalid && (best == nil || node.CumulativePoW.Cmp(best.CumulativePoW) > 0) {
+			best = node
+		}
+	}
+	idx.Best = best
+}
+
+// AddOrphan holds a block until its parent arrives, dropping an arbitrary
+// orphan if too many are held
+func (idx *BlockIndex) AddOrphan(b *block.Block) {
+	if len(idx.Orphans) >= MAXORPHANBLOCKS {
+		for blockID := range idx.Orphans {
+			delete(idx.Orphans, blockID)
+			break
+		}
+	}
+	idx.Orphans[b.BlockID] = b
+}
+
+// TakeOrphanChildren removes and returns the orphans whose parent is the given block
+func (idx *BlockIndex) TakeOrphanChildren(parentID string) []*block.Block {
+	children := make([]*block.Block, 0)
+	for blockID, orphan := range idx.Orphans {
+		if orphan.PrevHash == parentID {
+			children = append(children, orphan)
+			delete(idx.Orphans, blockID)
+		}
+	}
+	return children
+}
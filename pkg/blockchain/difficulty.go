@@ -0,0 +1,49 @@
+package blockchain
+
+import (
+	"math/big"
+
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/block"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/chaincfg"
+)
+
+// CalculateNextBits calculates the compact target of the block following
+// the given chain. The chain may be the main chain or a fork.
+func CalculateNextBits(params *chaincfg.Params, blocks []*block.Block) uint32 {
+	height := len(blocks)
+	prevBlock := blocks[height-1]
+
+	// The genesis block carries no target, and some networks never retarget
+	if height == 1 || params.NoRetargeting {
+		return params.InitialBits
+	}
+
+	// Keep the target between two adjustments
+	if height%params.RetargetInterval != 0 {
+		return prevBlock.Bits
+	}
+
+	// Skip the first window since the genesis block has no real timestamp
+	firstBlock := blocks[height-params.RetargetInterval]
+	if height-params.RetargetInterval == 0 {
+		return prevBlock.Bits
+	}
+
+	// Clamp the observed time span of the window
+	actualTimespan := prevBlock.Timestamp - firstBlock.Timestamp
+	targetTimespan := params.TargetBlockTime * int64(params.RetargetInterval-1)
+	actualTimespan = max(targetTimespan/params.RetargetFactor, min(actualTimespan, targetTimespan*params.RetargetFactor))
+
+	// Scale the target by the ratio of the observed and the target time span
+	newTarget := block.CompactToBig(prevBlock.Bits)
+	newTarget.Mul(newTarget, big.NewInt(actualTimespan))
+	newTarget.Div(newTarget, big.NewInt(targetTimespan))
+
+	// Never go above the easiest allowed target
+	powLimit := block.CompactToBig(params.PowLimitBits)
+	if newTarget.Cmp(powLimit) > 0 {
+		newTarget.Set(powLimit)
+	}
+
+	return block.BigToCompact(newTarget)
+}
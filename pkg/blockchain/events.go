@@ -0,0 +1,144 @@
+package blockchain
+
+import (
+	"sync"
+
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/block"
+)
+
+type EventType int
+
+const (
+	BLOCKCONNECTED    EventType = iota // A block was connected to the main chain
+	BLOCKDISCONNECTED                  // A block was disconnected from the main chain
+	TIPCHANGED                         // The tip moved, once all the blocks of the move are connected
+)
+
+// String returns the name of the event type
+func (t EventType) String() string {
+	switch t {
+	case BLOCKCONNECTED:
+		return "block-connected"
+	case BLOCKDISCONNECTED:
+		return "block-disconnected"
+	case TIPCHANGED:
+		return "tip-changed"
+	default:
+		return "unknown"
+	}
+}
+
+type Event struct {
+	Type   EventType    // Type of the event
+	Block  *block.Block // Connected or disconnected block, or the new tip
+	Height int          // Height of the block
+}
+
+// EventBus delivers the main chain events to its subscribers. Publishing
+// never blocks: every subscription queues its events until they are read,
+// so a slow subscriber neither holds up the blockchain nor misses an event.
+type EventBus struct {
+	subscriptions map[*Subscription]bool // Active subscriptions
+	mutex         *sync.Mutex            // Mutex protecting the subscriptions
+}
+
+type Subscription struct {
+	Events <-chan *Event // Events in the order they were published, closed once unsubscribed
+	events chan *Event   // Sending side of Events
+	queue  []*Event      // Events published but not delivered yet
+	signal chan struct{} // Channel waking the delivery up when an event is queued
+	done   chan struct{} // Channel closed once unsubscribed
+	bus    *EventBus     // Bus of the subscription
+	once   *sync.Once    // Guards the unsubscription
+	mutex  *sync.Mutex   // Mutex protecting the queue
+}
+
+// NewEventBus creates an event bus without subscribers
+func NewEventBus() *EventBus {
+	return &EventBus{
+		subscriptions: make(map[*Subscription]bool),
+		mutex:         &sync.Mutex{},
+	}
+}
+
+// Subscribe subscribes to the events published from now on
+func (bus *EventBus) Subscribe() *Subscription {
+	events := make(chan *Event)
+	sub := &Subscription{
+		Events: events,
+		events: events,
+		queue:  make([]*Event, 0),
+		signal: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+		bus:    bus,
+		once:   &sync.Once{},
+		mutex:  &sync.Mutex{},
+	}
+
+	bus.mutex.Lock()
+	bus.subscriptions[sub] = true
+	bus.mutex.Unlock()
+
+	go sub.deliver()
+	return sub
+}
+
+// Publish queues an event for every subscriber
+func (bus *EventBus) Publish(event *Event) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	for sub := range bus.subscriptions {
+		sub.enqueue(event)
+	}
+}
+
+// Unsubscribe stops the subscription and closes its events channel
+func (sub *Subscription) Unsubscribe() {
+	sub.once.Do(func() {
+		sub.bus.mutex.Lock()
+		delete(sub.bus.subscriptions, sub)
+		sub.bus.mutex.Unlock()
+
+		close(sub.done)
+	})
+}
+
+// enqueue queues an event and wakes the delivery up
+func (sub *Subscription) enqueue(event *Event) {
+	sub.mutex.Lock()
+	sub.queue = append(sub.queue, event)
+	sub.mutex.Unlock()
+
+	select {
+	case sub.signal <- struct{}{}:
+	default: // A wake up is already pending
+	}
+}
+
+// deliver sends the queued events to the subscriber until it unsubscribes
+func (sub *Subscription) deliver() {
+	defer close(sub.events)
+
+	for {
+		sub.mutex.Lock()
+		if len(sub.queue) == 0 {
+			sub.mutex.Unlock()
+			select {
+			case <-sub.signal:
+				continue
+			case <-sub.done:
+				return
+			}
+		}
+		event := sub.queue[0]
+		sub.queue = sub.queue[1:]
+		sub.mutex.Unlock()
+
+		select {
+		case sub.events <- event:
+		case <-sub.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package blockchain
+
+import (
+	"math/big"
+
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/block"
+)
+
+// GetBlockLocator returns block IDs from the tip back to the genesis block,
+// dense near the tip and exponentially sparser further back
+func (bc *Blockchain) GetBlockLocator() []string {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+
+	locator := make([]string, 0)
+	step := 1
+	for height := len(bc.Blocks) - 1; height > 0; height -= step {
+		locator = append(locator, bc.Blocks[height].BlockID)
+		if len(locator) >= 10 {
+			step *= 2
+		}
+	}
+
+	// Always end with the genesis block
+	return append(locator, bc.Blocks[0].BlockID)
+}
+
+// FindForkPoint returns the height of the first locator block found in the
+// main chain, or 0 (the genesis block) if none is found
+func (bc *Blockchain) FindForkPoint(locator []string) int {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+
+	for _, blockID := range locator {
+		if height := bc.findBlockHeight(blockID); height != -1 {
+			return height
+		}
+	}
+	return 0
+}
+
+// GetHeadersAfter returns up to n headers of the main chain following the given height
+func (bc *Blockchain) GetHeadersAfter(height int, n int) []*block.Header {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+
+	headers := make([]*block.Header, 0)
+	for h := height + 1; h < len(bc.Blocks) && len(headers) < n; h++ {
+		headers = append(headers, bc.Blocks[h].Header())
+	}
+	return headers
+}
+
+// GetBlockByID returns the main chain block with the given ID, or nil
+func (bc *Blockchain) GetBlockByID(blockID string) *block.Block {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+
+	height := bc.findBlockHeight(blockID)
+	if height == -1 {
+		return nil
+	}
+	return bc.Blocks[height]
+}
+
+// GetBlockHeight returns the height of the main chain block with the given ID, or -1
+func (bc *Blockchain) GetBlockHeight(blockID string) int {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+
+	return bc.findBlockHeight(blockID)
+}
+
+// GetTip returns the latest block and its height
+func (bc *Blockchain) GetTip() (*block.Block, int) {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+
+	return bc.GetLatestBlock(), len(bc.Blocks) - 1
+}
+
+// GetCumulativePoW returns the total work of the main chain
+func (bc *Blockchain) GetCumulativePoW() *big.Int {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+
+	return new(big.Int).Set(bc.CumulativePoW)
+}
+
+// HaveBlock checks if the block is known on any branch, or held as an orphan
+func (bc *Blockchain) HaveBlock(blockID string) bool {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+
+	_, known := bc.Index.Nodes[blockID]
+	_, orphan := bc.Index.Orphans[blockID]
+	return known || orphan
+}
+
+// GetKnownBlock returns the block with the given ID on any branch, or nil
+func (bc *Blockchain) GetKnownBlock(blockID string) *block.Block {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+
+	node, ok := bc.Index.Nodes[blockID]
+	if !ok {
+		return nil
+	}
+	return node.Block
+}
+
+// GetBlockWork returns the cumulative work of the chain ending at the block
+// with the given ID on any branch, or nil if the block is unknown
+func (bc *Blockchain) GetBlockWork(blockID string) *big.Int {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+
+	node, ok := bc.Index.Nodes[blockID]
+	if !ok {
+		return nil
+	}
+	return new(big.Int).Set(node.CumulativePoW)
+}
+
+// findBlockHeight returns the height of the main chain block with the given ID, or -1
+func (bc *Blockchain) findBlockHeight(blockID string) int {
+	node, ok := bc.Index.Nodes[blockID]
+	if !ok || !bc.isMainChain(node) {
+		return -1
+	}
+	return node.Height
+}
@@ -0,0 +1,68 @@
+package blockchain
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/block"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/store"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/chaincfg"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/mining/mempool"
+)
+
+// LoadBlockchain creates a blockchain backed by a block store. The stored
+// chain is revalidated block by block; it is cut at the first invalid block.
+func LoadBlockchain(params *chaincfg.Params, mempool *mempool.Mempool, blockStore *store.BlockStore) (*Blockchain, error) {
+	bc := NewBlockchain(params, mempool)
+	bc.Store = blockStore
+
+	// Load the stored chain
+	blocks, err := blockStore.LoadChain()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load stored chain: %v", err)
+	}
+
+	// Initialize an empty store with the genesis block
+	if len(blocks) == 0 {
+		bc.persistTip()
+		return bc, nil
+	}
+
+	if blocks[0].BlockID != bc.Blocks[0].BlockID {
+		return nil, fmt.Errorf("stored chain has a different genesis block: %s", blocks[0].BlockID)
+	}
+
+	// Revalidate and connect the stored blocks
+	for height, b := range blocks[1:] {
+		if err := bc.ValidateNewBlock(b); err != nil {
+			log.Printf("Stored block %d is invalid, truncating the chain: %v\n", height+1, err)
+			bc.persistTip()
+			break
+		}
+
+		bc.Blocks = append(bc.Blocks, b)
+		bc.CumulativePoW.Add(bc.CumulativePoW, block.CalcWork(b.Bits))
+		bc.connectBlock(b, height+1)
+		bc.Index.AddNode(b, bc.Index.Nodes[b.PrevHash])
+	}
+
+	log.Printf("Loaded %d blocks from %s\n", len(bc.Blocks), blockStore.Dir)
+	return bc, nil
+}
+
+// persistTip writes the latest block to the store and records it as the tip
+// of the stored chain
+func (bc *Blockchain) persistTip() {
+	if bc.Store == nil {
+		return
+	}
+
+	tip := bc.GetLatestBlock()
+	if err := bc.Store.PutBlock(tip); err != nil {
+		log.Printf("Failed to store block %s: %v\n", tip.BlockID, err)
+		return
+	}
+	if err := bc.Store.SetTip(tip.BlockID, len(bc.Blocks)-1); err != nil {
+		log.Printf("Failed to store chain tip: %v\n", err)
+	}
+}
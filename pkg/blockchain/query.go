@@ -0,0 +1,72 @@
+package blockchain
+
+import (
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/block"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/transaction"
+)
+
+// GetBlockByHeight returns the main chain block at the given height, or nil
+func (bc *Blockchain) GetBlockByHeight(height int) *block.Block {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+
+	if height < 0 || height >= len(bc.Blocks) {
+		return nil
+	}
+	return bc.Blocks[height]
+}
+
+type ChainBlock struct {
+	Block     *block.Block // Main chain block
+	Height    int          // Height of the block
+	TipHeight int          // Height of the tip when the block was read
+}
+
+// Confirmations returns the number of blocks from the block to the tip, both included
+func (cb *ChainBlock) Confirmations() int {
+	return cb.TipHeight - cb.Height + 1
+}
+
+// GetChainBlockByHeight returns the main chain block at the given height
+// with the height of the tip, read at once, or nil
+func (bc *Blockchain) GetChainBlockByHeight(height int) *ChainBlock {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+
+	return bc.chainBlock(height)
+}
+
+// GetChainBlockByID returns the main chain block with the given ID with its
+// height and the height of the tip, read at once, or nil
+func (bc *Blockchain) GetChainBlockByID(blockID string) *ChainBlock {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+
+	return bc.chainBlock(bc.findBlockHeight(blockID))
+}
+
+// GetTransaction returns a confirmed transaction and the block holding it,
+// or nil if the transaction is not in the main chain
+func (bc *Blockchain) GetTransaction(txID string) (*transaction.Transaction, *ChainBlock) {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+
+	tx, height := bc.findTransaction(txID)
+	if tx == nil {
+		return nil, nil
+	}
+	return tx, bc.chainBlock(height)
+}
+
+// chainBlock returns the main chain block at the given height, or nil; the
+// caller holds the mutex
+func (bc *Blockchain) chainBlock(height int) *ChainBlock {
+	if height < 0 || height >= len(bc.Blocks) {
+		return nil
+	}
+	return &ChainBlock{
+		Block:     bc.Blocks[height],
+		Height:    height,
+		TipHeight: len(bc.Blocks) - 1,
+	}
+}
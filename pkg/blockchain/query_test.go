@@ -0,0 +1,62 @@
+package blockchain_test
+
+import (
+	"testing"
+
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/block"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/wallet"
+)
+
+func TestGetChainBlock(t *testing.T) {
+	bc := newTestBlockchain()
+	genesis := bc.GetLatestBlock()
+	miner := wallet.NewWallet()
+	other := wallet.NewWallet()
+
+	// A stale branch, then the main chain
+	stale := extendChain(t, bc, genesis, 1, 1, other.GetAddress())[0]
+	chain := extendChain(t, bc, genesis, 1, 3, miner.GetAddress())
+
+	tests := []struct {
+		name       string
+		get        func() *blockchain.ChainBlock
+		want       *block.Block
+		wantHeight int
+	}{
+		{name: "genesis by height", get: func() *blockchain.ChainBlock { return bc.GetChainBlockByHeight(0) }, want: genesis, wantHeight: 0},
+		{name: "tip by height", get: func() *blockchain.ChainBlock { return bc.GetChainBlockByHeight(3) }, want: chain[2], wantHeight: 3},
+		{name: "block by ID", get: func() *blockchain.ChainBlock { return bc.GetChainBlockByID(chain[0].BlockID) }, want: chain[0], wantHeight: 1},
+		{name: "height above the tip", get: func() *blockchain.ChainBlock { return bc.GetChainBlockByHeight(4) }, want: nil},
+		{name: "negative height", get: func() *blockchain.ChainBlock { return bc.GetChainBlockByHeight(-1) }, want: nil},
+		{name: "block of a stale branch", get: func() *blockchain.ChainBlock { return bc.GetChainBlockByID(stale.BlockID) }, want: nil},
+		{name: "unknown block", get: func() *blockchain.ChainBlock { return bc.GetChainBlockByID("unknown") }, want: nil},
+		{
+			name: "block of a confirmed transaction",
+			get: func() *blockchain.ChainBlock {
+				_, cb := bc.GetTransaction(chain[1].Transactions[0].TransactionID)
+				return cb
+			},
+			want:       chain[1],
+			wantHeight: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb := tt.get()
+			if tt.want == nil {
+				if cb != nil {
+					t.Fatalf("got block %s at height %d, want none", cb.Block.BlockID, cb.Height)
+				}
+				return
+			}
+			if cb == nil || cb.Block != tt.want {
+				t.Fatalf("got %v, want block %s", cb, tt.want.BlockID)
+			}
+			if cb.Height != tt.wantHeight || cb.TipHeight != 3 || cb.Confirmations() != 4-tt.wantHeight {
+				t.Errorf("height %d, tip height %d, confirmations %d, want %d, 3, %d", cb.Height, cb.TipHeight, cb.Confirmations(), tt.wantHeight, 4-tt.wantHeight)
+			}
+		})
+	}
+}
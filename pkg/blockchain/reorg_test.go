@@ -0,0 +1,184 @@
+package blockchain_test
+
+import (
+	"testing"
+
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/amount"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/block"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/transaction"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/utxo"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/chaincfg"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/mining/mempool"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/wallet"
+)
+
+const testMaturity = 3 // Coinbase maturity of the test chains
+
+// newTestBlockchain creates a regtest blockchain with a short coinbase maturity
+func newTestBlockchain() *blockchain.Blockchain {
+	params := chaincfg.RegTestParams
+	params.CoinbaseMaturity = testMaturity
+	return blockchain.NewBlockchain(&params, mempool.NewMempool())
+}
+
+// mineBlock builds a block with the given transactions on top of the parent
+// at the given height, paying the miner, and performs its proof of work
+func mineBlock(t *testing.T, bc *blockchain.Blockchain, parent *block.Block, height int, miner string, txs []*transaction.Transaction) *block.Block {
+	t.Helper()
+
+	reward, err := bc.CalculateReward(height, txs)
+	if err != nil {
+		t.Fatalf("failed to calculate the reward: %v", err)
+	}
+	b := block.NewBlock(parent.BlockID, height, txs, miner, reward, bc.Params.InitialBits, parent.Timestamp+1)
+	for !block.MeetsTarget(b.BlockID, b.Bits) {
+		b.Nonce++
+		b.BlockID = b.Hash()
+	}
+	return b
+}
+
+// extendChain mines n blocks on top of the parent at the given height and
+// processes them, returning the blocks
+func extendChain(t *testing.T, bc *blockchain.Blockchain, parent *block.Block, height, n int, miner string) []*block.Block {
+	t.Helper()
+
+	blocks := make([]*block.Block, 0, n)
+	for i := 0; i < n; i++ {
+		b := mineBlock(t, bc, parent, height+i, miner, nil)
+		if _, err := bc.ProcessBlock(b); err != nil {
+			t.Fatalf("failed to process block at height %d: %v", height+i, err)
+		}
+		blocks = append(blocks, b)
+		parent = b
+	}
+	return blocks
+}
+
+// spendCoinbase returns a transaction of the wallet spending the coinbase of the block
+func spendCoinbase(t *testing.T, w *wallet.Wallet, b *block.Block) *transaction.Transaction {
+	t.Helper()
+
+	coinbase := b.Transactions[0]
+	fee := amount.Amount(100_000)
+	inputs := []*transaction.TxInput{transaction.NewTxInput(coinbase.TransactionID, 0)}
+	outputs := []*transaction.TxOutput{transaction.NewTxOutput(coinbase.Outputs[0].Value-fee, w.GetAddress())}
+	tx := transaction.NewUnsignedTransaction(w.GetAddress(), inputs, outputs, fee)
+
+	signature, err := w.Sign(tx.Hash())
+	if err != nil {
+		t.Fatalf("failed to sign transaction: %v", err)
+	}
+	tx.Signature = signature
+	tx.TransactionID = tx.GenerateTransactionID()
+	return tx
+}
+
+func TestReorganizeDropsDisconnectedCoinbases(t *testing.T) {
+	tests := []struct {
+		name       string
+		mainLength int // Blocks of the main chain mined by the wallet
+		forkLength int // Blocks of the competing branch from the genesis block
+		spendAt    int // Height of the main chain block whose coinbase is spent by a pending transaction, or 0
+	}{
+		{name: "longer branch", mainLength: 2, forkLength: 3},
+		{name: "much longer branch", mainLength: 1, forkLength: 5},
+		{name: "pending spend of a disconnected coinbase", mainLength: 4, forkLength: 6, spendAt: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc := newTestBlockchain()
+			genesis := bc.GetLatestBlock()
+			miner := wallet.NewWallet()
+			other := wallet.NewWallet()
+
+			// Mine the main chain and spend one of its coinbases
+			mainChain := extendChain(t, bc, genesis, 1, tt.mainLength, miner.GetAddress())
+			var pending *transaction.Transaction
+			if tt.spendAt > 0 {
+				pending = spendCoinbase(t, miner, mainChain[tt.spendAt-1])
+				if err := bc.ValidateTransaction(pending); err != nil {
+					t.Fatalf("mature coinbase spend rejected: %v", err)
+				}
+				if err := bc.Mempool.AddTransaction(pending); err != nil {
+					t.Fatalf("failed to add the transaction to the mempool: %v", err)
+				}
+			}
+
+			// Switch to a competing branch with more work
+			fork := extendChain(t, bc, genesis, 1, tt.forkLength, other.GetAddress())
+			if tip, _ := bc.GetTip(); tip.BlockID != fork[len(fork)-1].BlockID {
+				t.Fatalf("tip = %s, want the tip of the competing branch %s", tip.BlockID, fork[len(fork)-1].BlockID)
+			}
+
+			// The coinbases of the disconnected blocks are gone
+			for _, b := range mainChain {
+				op := utxo.NewOutPoint(b.Transactions[0].TransactionID, 0)
+				if _, ok := bc.UTXOSet.Get(op); ok {
+					t.Errorf("coinbase %s of a disconnected block is still unspent", op)
+				}
+			}
+			if balance := bc.GetBalance(miner.GetAddress()); balance != 0 {
+				t.Errorf("balance of the disconnected miner = %s, want 0", balance)
+			}
+			for _, b := range fork {
+				op := utxo.NewOutPoint(b.Transactions[0].TransactionID, 0)
+				if _, ok := bc.UTXOSet.Get(op); !ok {
+					t.Errorf("coinbase %s of the new main chain is missing", op)
+				}
+			}
+
+			// The transactions spending them are dropped from the mempool
+			for _, tx := range bc.Mempool.GetTransactions() {
+				if tx.IsCoinbase() {
+					t.Errorf("coinbase %s returned to the mempool", tx.TransactionID)
+				}
+			}
+			if pending != nil && bc.Mempool.GetTransaction(pending.TransactionID) != nil {
+				t.Errorf("transaction %s spending a disconnected coinbase is still pending", pending.TransactionID)
+			}
+		})
+	}
+}
+
+func TestCoinbaseMaturity(t *testing.T) {
+	tests := []struct {
+		name        string
+		chainLength int // Blocks mined before the spend
+		spentHeight int // Height of the block whose coinbase is spent
+		wantErr     bool
+	}{
+		{name: "coinbase of the tip", chainLength: 1, spentHeight: 1, wantErr: true},
+		{name: "one block short", chainLength: testMaturity - 1, spentHeight: 1, wantErr: true},
+		{name: "just mature", chainLength: testMaturity, spentHeight: 1, wantErr: false},
+		{name: "deep coinbase", chainLength: testMaturity + 3, spentHeight: 2, wantErr: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc := newTestBlockchain()
+			miner := wallet.NewWallet()
+			chain := extendChain(t, bc, bc.GetLatestBlock(), 1, tt.chainLength, miner.GetAddress())
+			tx := spendCoinbase(t, miner, chain[tt.spentHeight-1])
+
+			// The mempool only accepts a spend that is mature in the next block
+			err := bc.ValidateTransaction(tx)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateTransaction() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			// A block including the spend is only connected if it is mature
+			tip := chain[len(chain)-1]
+			b := mineBlock(t, bc, tip, tt.chainLength+1, miner.GetAddress(), []*transaction.Transaction{tx})
+			tipChanged, err := bc.ProcessBlock(b)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ProcessBlock() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tipChanged == tt.wantErr {
+				t.Errorf("ProcessBlock() tip changed = %v, want %v", tipChanged, !tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/block"
+)
+
+const (
+	BLOCKFILE  = "blocks.dat" // Append-only file holding every stored block
+	TIPFILE    = "tip.json"   // Record of the active chain tip
+	HEADERSIZE = 8            // Record header: 4-byte length + 4-byte checksum
+	MAXRECORD  = 32 << 20     // Upper bound on the size of a single block record
+)
+
+type Tip struct {
+	BlockID string `json:"block_id"` // ID of the tip block
+	Height  int    `json:"height"`   // Height of the tip block
+}
+
+type BlockStore struct {
+	Dir     string           // Directory holding the store files
+	file    *os.File         // Append-only block file
+	offsets map[string]int64 // BlockID -> Offset of the block record in the block file
+	heights []string         // Height -> BlockID of the active chain
+	mutex   *sync.RWMutex    // Mutex to protect the store
+}
+
+// OpenBlockStore opens the block store in the given directory, creating it if needed
+func OpenBlockStore(dir string) (*BlockStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create data directory %s: %v", dir, err)
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, BLOCKFILE), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open block file: %v", err)
+	}
+
+	s := &BlockStore{
+		Dir:     dir,
+		file:    file,
+		offsets: make(map[string]int64),
+		heights: make([]string, 0),
+		mutex:   &sync.RWMutex{},
+	}
+
+	// Rebuild the hash index from the block file
+	if err := s.reindex(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// reindex scans the block file and rebuilds the hash index. A torn record
+// left by a crash in the middle of a write is truncated away.
+func (s *BlockStore) reindex() error {
+	var offset int64
+	for {
+		b, size, err := s.readRecord(offset)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("Truncating block file at offset %d: %v\n", offset, err)
+			if err := s.file.Truncate(offset); err != nil {
+				return fmt.Errorf("failed to truncate block file: %v", err)
+			}
+			break
+		}
+
+		s.offsets[b.BlockID] = offset
+		offset += size
+	}
+
+	return nil
+}
+
+// readRecord reads the block record at the given offset and returns the block
+// and the record size
+func (s *BlockStore) readRecord(offset int64) (*block.Block, int64, error) {
+	// Read the record header
+	header := make([]byte, HEADERSIZE)
+	n, err := s.file.ReadAt(header, offset)
+	if n == 0 && err == io.EOF {
+		return nil, 0, io.EOF
+	}
+	if n < HEADERSIZE {
+		return nil, 0, fmt.Errorf("incomplete record header")
+	}
+	length := binary.BigEndian.Uint32(header[:4])
+	checksum := header[4:]
+	if length > MAXRECORD {
+		return nil, 0, fmt.Errorf("record too large: %d bytes", length)
+	}
+
+	// Read the record data
+	data := make([]byte, length)
+	if _, err := s.file.ReadAt(data, offset+HEADERSIZE); err != nil {
+		return nil, 0, fmt.Errorf("incomplete record data: %v", err)
+	}
+	if !bytes.Equal(recordChecksum(data), checksum) {
+		return nil, 0, fmt.Errorf("record checksum mismatch")
+	}
+
+	b, err := block.DeserializeBlock(string(data))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return b, HEADERSIZE + int64(length), nil
+}
+
+// PutBlock appends a block to the block file if it is not stored yet
+func (s *BlockStore) PutBlock(b *block.Block) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.offsets[b.BlockID]; ok {
+		return nil
+	}
+
+	data, err := b.Serialize()
+	if err != nil {
+		return err
+	}
+
+	// Build the record: length, checksum and data
+	record := make([]byte, HEADERSIZE+len(data))
+	binary.BigEndian.PutUint32(record[:4], uint32(len(data)))
+	copy(record[4:HEADERSIZE], recordChecksum([]byte(data)))
+	copy(record[HEADERSIZE:], data)
+
+	// Append the record and flush it to disk
+	offset, err := s.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("failed to seek block file: %v", err)
+	}
+	if _, err := s.file.WriteAt(record, offset); err != nil {
+		return fmt.Errorf("failed to write block: %v", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync block file: %v", err)
+	}
+
+	s.offsets[b.BlockID] = offset
+	return nil
+}
+
+// GetBlock returns the stored block with the given ID
+func (s *BlockStore) GetBlock(blockID string) (*block.Block, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	offset, ok := s.offsets[blockID]
+	if !ok {
+		return nil, fmt.Errorf("block %s not found", blockID)
+	}
+
+	b, _, err := s.readRecord(offset)
+	return b, err
+}
+
+// GetBlockByHeight returns the block at the given height of the active chain
+func (s *BlockStore) GetBlockByHeight(height int) (*block.Block, error) {
+	s.mutex.RLock()
+	if height < 0 || height >= len(s.heights) {
+		s.mutex.RUnlock()
+		return nil, fmt.Errorf("no block at height %d", height)
+	}
+	blockID := s.heights[height]
+	s.mutex.RUnlock()
+
+	return s.GetBlock(blockID)
+}
+
+// SetTip records a stored block as the tip of the active chain
+func (s *BlockStore) SetTip(blockID string, height int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.offsets[blockID]; !ok {
+		return fmt.Errorf("block %s not found", blockID)
+	}
+	if height < 0 || height > len(s.heights) {
+		return fmt.Errorf("invalid tip height: %d", height)
+	}
+
+	// Write the tip record atomically
+	data, err := json.Marshal(&Tip{BlockID: blockID, Height: height})
+	if err != nil {
+		return fmt.Errorf("failed to serialize tip: %v", err)
+	}
+	if err := writeFileAtomic(filepath.Join(s.Dir, TIPFILE), data); err != nil {
+		return err
+	}
+
+	// Update the height index
+	s.heights = append(s.heights[:height], blockID)
+	return nil
+}
+
+// GetTip returns the tip record, or nil if no tip has been recorded
+func (s *BlockStore) GetTip() (*Tip, error) {
+	data, err := os.ReadFile(filepath.Join(s.Dir, TIPFILE))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tip: %v", err)
+	}
+
+	var tip Tip
+	if err := json.Unmarshal(data, &tip); err != nil {
+		return nil, fmt.Errorf("failed to deserialize tip: %v", err)
+	}
+	return &tip, nil
+}
+
+// LoadChain loads the active chain from the genesis block up to the recorded tip
+func (s *BlockStore) LoadChain() ([]*block.Block, error) {
+	tip, err := s.GetTip()
+	if err != nil || tip == nil {
+		return nil, err
+	}
+
+	// Walk back from the tip to the genesis block
+	blocks := make([]*block.Block, tip.Height+1)
+	blockID := tip.BlockID
+	for height := tip.Height; height >= 0; height-- {
+		b, err := s.GetBlock(blockID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load block at height %d: %v", height, err)
+		}
+		blocks[height] = b
+		blockID = b.PrevHash
+	}
+
+	// Rebuild the height index
+	s.mutex.Lock()
+	s.heights = make([]string, len(blocks))
+	for height, b := range blocks {
+		s.heights[height] = b.BlockID
+	}
+	s.mutex.Unlock()
+
+	return blocks, nil
+}
+
+// Close closes the block store
+func (s *BlockStore) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.file.Close()
+}
+
+// recordChecksum returns the first 4 bytes of the SHA256 hash of the data
+func recordChecksum(data []byte) []byte {
+	hash := sha256.Sum256(data)
+	return hash[:4]
+}
+
+// writeFileAtomic writes a file through a synced temporary file and a rename,
+// so a crash never leaves a partially written file behind
+func writeFileAtomic(filename string, data []byte) error {
+	tmpFile := filename + ".tmp"
+	file, err := os.OpenFile(tmpFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", tmpFile, err)
+	}
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write %s: %v", tmpFile, err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to sync %s: %v", tmpFile, err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %v", tmpFile, err)
+	}
+
+	if err := os.Rename(tmpFile, filename); err != nil {
+		return fmt.Errorf("failed to rename %s: %v", tmpFile, err)
+	}
+	return nil
+}
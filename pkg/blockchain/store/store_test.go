@@ -0,0 +1,176 @@
+package store_test
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/block"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/store"
+)
+
+// testChain returns a chain of n blocks from a genesis block, without proof
+// of work, which the store does not check
+func testChain(n int) []*block.Block {
+	blocks := []*block.Block{block.NewGenesisBlock(2)}
+	for height := 1; height < n; height++ {
+		parent := blocks[height-1]
+		blocks = append(blocks, block.NewBlock(parent.BlockID, height, nil, "miner", 50, 0x207fffff, parent.Timestamp+1))
+	}
+	return blocks
+}
+
+// openStore opens the block store in the directory
+func openStore(t *testing.T, dir string) *store.BlockStore {
+	t.Helper()
+
+	s, err := store.OpenBlockStore(dir)
+	if err != nil {
+		t.Fatalf("OpenBlockStore() error = %v", err)
+	}
+	return s
+}
+
+// putChain stores the blocks and records each as the tip in turn
+func putChain(t *testing.T, s *store.BlockStore, blocks []*block.Block) {
+	t.Helper()
+
+	for height, b := range blocks {
+		if err := s.PutBlock(b); err != nil {
+			t.Fatalf("PutBlock() at height %d error = %v", height, err)
+		}
+		if err := s.SetTip(b.BlockID, height); err != nil {
+			t.Fatalf("SetTip() at height %d error = %v", height, err)
+		}
+	}
+}
+
+// assertChain checks that the store loads the blocks as its active chain
+func assertChain(t *testing.T, s *store.BlockStore, blocks []*block.Block) {
+	t.Helper()
+
+	loaded, err := s.LoadChain()
+	if err != nil {
+		t.Fatalf("LoadChain() error = %v", err)
+	}
+	if len(loaded) != len(blocks) {
+		t.Fatalf("LoadChain() = %d blocks, want %d", len(loaded), len(blocks))
+	}
+	for height, b := range blocks {
+		if loaded[height].BlockID != b.BlockID {
+			t.Errorf("block at height %d = %s, want %s", height, loaded[height].BlockID, b.BlockID)
+		}
+	}
+}
+
+func TestReopenTruncatesTornRecord(t *testing.T) {
+	tests := []struct {
+		name string
+		torn func(record []byte) []byte // Tail left by a crash while writing the record
+	}{
+		{
+			name: "torn header",
+			torn: func(record []byte) []byte { return record[:store.HEADERSIZE-1] },
+		},
+		{
+			name: "torn data",
+			torn: func(record []byte) []byte { return record[:len(record)-1] },
+		},
+		{
+			name: "corrupted data",
+			torn: func(record []byte) []byte { record[len(record)-1] ^= 0xff; return record },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			blocks := testChain(4)
+
+			s := openStore(t, dir)
+			putChain(t, s, blocks[:3])
+			s.Close()
+
+			// Append the torn record of the next block
+			filename := filepath.Join(dir, store.BLOCKFILE)
+			info, err := os.Stat(filename)
+			if err != nil {
+				t.Fatalf("failed to stat block file: %v", err)
+			}
+			data, err := blocks[3].Serialize()
+			if err != nil {
+				t.Fatalf("Serialize() error = %v", err)
+			}
+			record := make([]byte, store.HEADERSIZE+len(data))
+			checksum := sha256.Sum256([]byte(data))
+			binary.BigEndian.PutUint32(record[:4], uint32(len(data)))
+			copy(record[4:store.HEADERSIZE], checksum[:4])
+			copy(record[store.HEADERSIZE:], data)
+			file, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0600)
+			if err != nil {
+				t.Fatalf("failed to open block file: %v", err)
+			}
+			if _, err := file.Write(tt.torn(record)); err != nil {
+				t.Fatalf("failed to write torn record: %v", err)
+			}
+			file.Close()
+
+			// The torn record is truncated away, keeping the stored chain
+			s = openStore(t, dir)
+			truncated, err := os.Stat(filename)
+			if err != nil {
+				t.Fatalf("failed to stat block file: %v", err)
+			}
+			if truncated.Size() != info.Size() {
+				t.Fatalf("block file size after reopening = %d, want %d", truncated.Size(), info.Size())
+			}
+			if _, err := s.GetBlock(blocks[3].BlockID); err == nil {
+				t.Errorf("GetBlock() of the torn block succeeded")
+			}
+			assertChain(t, s, blocks[:3])
+
+			// The block is stored again after the last complete record
+			putChain(t, s, blocks)
+			s.Close()
+			s = openStore(t, dir)
+			defer s.Close()
+			assertChain(t, s, blocks)
+		})
+	}
+}
+
+func TestReopenLoadsTip(t *testing.T) {
+	dir := t.TempDir()
+	blocks := testChain(5)
+	fork := block.NewBlock(blocks[2].BlockID, 3, nil, "other miner", 50, 0x207fffff, blocks[2].Timestamp+2)
+
+	// The chain moves to height 4, then back to a fork at height 3
+	s := openStore(t, dir)
+	putChain(t, s, blocks)
+	putChain(t, s, []*block.Block{blocks[0], blocks[1], blocks[2], fork})
+	s.Close()
+
+	s = openStore(t, dir)
+	defer s.Close()
+	tip, err := s.GetTip()
+	if err != nil || tip == nil {
+		t.Fatalf("GetTip() = %v, %v", tip, err)
+	}
+	if tip.BlockID != fork.BlockID || tip.Height != 3 {
+		t.Errorf("GetTip() = %s at height %d, want %s at height 3", tip.BlockID, tip.Height, fork.BlockID)
+	}
+	assertChain(t, s, []*block.Block{blocks[0], blocks[1], blocks[2], fork})
+
+	// The height index follows the tip, while the blocks left are still stored
+	if b, err := s.GetBlockByHeight(3); err != nil || b.BlockID != fork.BlockID {
+		t.Errorf("GetBlockByHeight(3) = %v, %v, want the fork block", b, err)
+	}
+	if _, err := s.GetBlockByHeight(4); err == nil {
+		t.Errorf("GetBlockByHeight(4) succeeded above the tip")
+	}
+	if _, err := s.GetBlock(blocks[4].BlockID); err != nil {
+		t.Errorf("GetBlock() of a block off the active chain error = %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package blockchain
+
+import (
+	"fmt"
+
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/amount"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/transaction"
+)
+
+type SupplyAudit struct {
+	Height          int           `json:"height"`           // Height of the audited tip
+	ScheduledSupply amount.Amount `json:"scheduled_supply"` // Total subsidy due up to the tip
+	IssuedSupply    amount.Amount `json:"issued_supply"`    // Total coinbase outputs minus the fees they collected
+	UTXOSupply      amount.Amount `json:"utxo_supply"`      // Total value of the unspent outputs
+	MaxSupply       amount.Amount `json:"max_supply"`       // Upper bound on the supply
+}
+
+// AuditSupply checks the coinbase outputs of the main chain against the
+// subsidy schedule and the unspent outputs against the issued supply
+func (bc *Blockchain) AuditSupply() (*SupplyAudit, error) {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+
+	height := len(bc.Blocks) - 1
+	audit := &SupplyAudit{
+		Height:          height,
+		ScheduledSupply: bc.Params.Subsidy.ScheduledSupply(height),
+		MaxSupply:       bc.Params.Subsidy.MaxSupply,
+	}
+
+	// Sum the subsidies actually paid by the coinbases
+	for h, b := range bc.Blocks[1:] {
+		coinbaseOutput, err := b.Transactions[0].TotalOutput()
+		if err != nil {
+			return audit, fmt.Errorf("block %d: %v", h+1, err)
+		}
+		fees, err := totalFees(b.Transactions[1:])
+		if err != nil {
+			return audit, fmt.Errorf("block %d: %v", h+1, err)
+		}
+
+		subsidy := coinbaseOutput - fees
+		if subsidy != bc.Params.Subsidy.BlockSubsidy(h+1) {
+			return audit, fmt.Errorf("block %d pays a subsidy of %s", h+1, subsidy)
+		}
+		if audit.IssuedSupply, err = audit.IssuedSupply.Add(subsidy); err != nil {
+			return audit, err
+		}
+	}
+
+	// Sum the unspent outputs
+	for _, output := range bc.UTXOSet.UTXOs {
+		var err error
+		if audit.UTXOSupply, err = audit.UTXOSupply.Add(output.Value); err != nil {
+			return audit, err
+		}
+	}
+
+	if audit.IssuedSupply != audit.ScheduledSupply {
+		return audit, fmt.Errorf("issued supply %s does not match the schedule %s", audit.IssuedSupply, audit.ScheduledSupply)
+	}
+	// Transactions spend their inputs exactly into their outputs and fee, so
+	// no coin leaves the unspent outputs once issued
+	if audit.UTXOSupply != audit.IssuedSupply {
+		return audit, fmt.Errorf("unspent outputs %s do not match the issued supply %s", audit.UTXOSupply, audit.IssuedSupply)
+	}
+	if audit.IssuedSupply > audit.MaxSupply {
+		return audit, fmt.Errorf("issued supply %s exceeds the maximum supply %s", audit.IssuedSupply, audit.MaxSupply)
+	}
+	return audit, nil
+}
+
+// totalFees returns the sum of the fees of the transactions
+func totalFees(transactions []*transaction.Transaction) (amount.Amount, error) {
+	fees := amount.Amount(0)
+	for _, tx := range transactions {
+		var err error
+		if fees, err = fees.Add(tx.Fee); err != nil {
+			return 0, err
+		}
+	}
+	return fees, nil
+}
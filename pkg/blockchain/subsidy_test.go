@@ -0,0 +1,43 @@
+package blockchain_test
+
+import (
+	"testing"
+
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/transaction"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/wallet"
+)
+
+func TestAuditSupply(t *testing.T) {
+	tests := []struct {
+		name        string
+		chainLength int  // Blocks mined before the audit
+		withSpend   bool // Whether a block spends a coinbase, paying a fee
+	}{
+		{name: "coinbases only", chainLength: testMaturity + 2, withSpend: false},
+		{name: "spend paying a fee", chainLength: testMaturity, withSpend: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc := newTestBlockchain()
+			miner := wallet.NewWallet()
+			chain := extendChain(t, bc, bc.GetLatestBlock(), 1, tt.chainLength, miner.GetAddress())
+
+			if tt.withSpend {
+				tx := spendCoinbase(t, miner, chain[0])
+				b := mineBlock(t, bc, chain[len(chain)-1], tt.chainLength+1, miner.GetAddress(), []*transaction.Transaction{tx})
+				if _, err := bc.ProcessBlock(b); err != nil {
+					t.Fatalf("failed to process the block spending a coinbase: %v", err)
+				}
+			}
+
+			audit, err := bc.AuditSupply()
+			if err != nil {
+				t.Fatalf("AuditSupply() error = %v", err)
+			}
+			if audit.UTXOSupply != audit.IssuedSupply || audit.IssuedSupply != audit.ScheduledSupply {
+				t.Errorf("unspent outputs %s, issued supply %s, scheduled supply %s, want all equal", audit.UTXOSupply, audit.IssuedSupply, audit.ScheduledSupply)
+			}
+		})
+	}
+}
@@ -1,99 +1,248 @@
 package blockchain
 
 import (
+	"errors"
 	"fmt"
+	"log"
 
 	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/block"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/transaction"
 )
 
-// ShouldSwitchChain determines if the current chain should be replaced with a new chain
-func (bc *Blockchain) ShouldSwitchChain(fork *Blockchain) error {
-	// Validate the new chain
-	if err := fork.Validate(); err != nil {
-		return err
+// ErrOrphanBlock is returned for a block whose parent is not known yet
+var ErrOrphanBlock = errors.New("orphan block")
+
+// ErrKnownBlock is returned for a block already in the block index
+var ErrKnownBlock = errors.New("block is already known")
+
+// ProcessBlock accepts a block of any branch into the block index and moves
+// the tip to the valid branch with the most work. It reports whether the tip
+// changed, publishing a TIPCHANGED event if it did, and returns
+// ErrOrphanBlock if the parent of the block is unknown, or ErrKnownBlock if
+// the block was already received.
+func (bc *Blockchain) ProcessBlock(b *block.Block) (bool, error) {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	if _, ok := bc.Index.Nodes[b.BlockID]; ok {
+		return false, ErrKnownBlock
+	}
+	if _, ok := bc.Index.Orphans[b.BlockID]; ok {
+		return false, ErrOrphanBlock
 	}
 
-	// Compare the cumulative difficulty of the two chains
-	if fork.CumulativePoW <= bc.CumulativePoW {
-		return fmt.Errorf("new chain has lower cumulative PoW")
+	// Validate the block on its own
+	if err := b.Validate(bc.Params); err != nil {
+		return false, err
 	}
 
-	return nil
-}
+	// Hold the block until its parent arrives
+	parent, ok := bc.Index.Nodes[b.PrevHash]
+	if !ok {
+		bc.Index.AddOrphan(b)
+		return false, ErrOrphanBlock
+	}
 
-// SwitchChain switches the current chain with a new chain
-func (bc *Blockchain) SwitchChain(fork *Blockchain) error {
-	bc.mutex.Lock()
-	defer bc.mutex.Unlock()
+	// Accept the block, then the orphans waiting for it
+	if err := bc.acceptBlock(b, parent); err != nil {
+		return false, err
+	}
+	for queue := []string{b.BlockID}; len(queue) > 0; queue = queue[1:] {
+		parent := bc.Index.Nodes[queue[0]]
+		for _, orphan := range bc.Index.TakeOrphanChildren(parent.BlockID) {
+			if err := bc.acceptBlock(orphan, parent); err != nil {
+				log.Printf("Dropping orphan block %s: %v\n", orphan.BlockID, err)
+				continue
+			}
+			queue = append(queue, orphan.BlockID)
+		}
+	}
 
-	// Validate the new chain
-	if err := bc.ShouldSwitchChain(fork); err != nil {
-		return err
+	// Move the tip to the branch with the most work
+	tipChanged, err := bc.activateBestChain()
+	if tipChanged {
+		bc.Events.Publish(&Event{Type: TIPCHANGED, Block: bc.GetLatestBlock(), Height: len(bc.Blocks) - 1})
 	}
+	return tipChanged, err
+}
 
-	// Find the last common block
-	lca := bc.FindLastCommonBlock(fork)
+// acceptBlock validates the header of a block against its branch and adds the
+// block to the block index. The transactions are validated once the block
+// is connected to the main chain.
+func (bc *Blockchain) acceptBlock(b *block.Block, parent *BlockNode) error {
+	if parent.Invalid {
+		return fmt.Errorf("block %s extends an invalid block", b.BlockID)
+	}
 
-	// Remove diverging blocks
-	bc.RemoveDivergingBlocks(lca)
+	// Validate the target and the timestamp against the branch
+	prevBlocks := bc.branchBlocks(parent)
+	if err := bc.validateBits(b, prevBlocks); err != nil {
+		return err
+	}
+	if err := bc.validateTimestamp(b, prevBlocks); err != nil {
+		return err
+	}
 
-	// Append the new blocks
-	bc.AppendForkBlocks(fork.Blocks, lca)
+	bc.Index.AddNode(b, parent)
 
+	// Store the block so that it can be served to peers
+	if bc.Store != nil {
+		if err := bc.Store.PutBlock(b); err != nil {
+			log.Printf("Failed to store block %s: %v\n", b.BlockID, err)
+		}
+	}
 	return nil
 }
 
-// FindLastCommonBlock finds the last common block between two chains
-func (bc *Blockchain) FindLastCommonBlock(fork *Blockchain) *block.Block {
-	for i := len(bc.Blocks) - 1; i >= 0; i-- {
-		for j := len(fork.Blocks) - 1; j >= 0; j-- {
-			if bc.Blocks[i].BlockID == fork.Blocks[j].BlockID {
-				return bc.Blocks[i] // LCA found
+// activateBestChain reorganizes the main chain onto the valid branch with
+// the most work. A branch holding an invalid block is given up for the next
+// best one.
+func (bc *Blockchain) activateBestChain() (bool, error) {
+	tipChanged := false
+	var lastErr error
+	for bc.Index.Best.CumulativePoW.Cmp(bc.CumulativePoW) > 0 {
+		if err := bc.reorganize(bc.Index.Best); err != nil {
+			log.Printf("Failed to activate the best chain: %v\n", err)
+			lastErr = err
+			continue
+		}
+		tipChanged = true
+	}
+	return tipChanged, lastErr
+}
+
+// reorganize moves the tip to the target node: it disconnects the main chain
+// down to the last common block, then connects the blocks of the target
+// branch. If a block of the branch is invalid, it is marked as such and the
+// previous main chain is restored. The mempool is then revalidated against
+// the new tip.
+func (bc *Blockchain) reorganize(target *BlockNode) error {
+	// Collect the branch from the last common block to the target
+	branch := make([]*BlockNode, 0)
+	fork := target
+	for ; !bc.isMainChain(fork); fork = fork.Parent {
+		branch = append([]*BlockNode{fork}, branch...)
+	}
+
+	// Disconnect the main chain down to the last common block, tip first
+	disconnected := make([]*block.Block, 0)
+	for len(bc.Blocks)-1 > fork.Height {
+		disconnected = append(disconnected, bc.disconnectTip())
+	}
+
+	// Connect the blocks of the branch
+	for i, node := range branch {
+		if err := bc.connectTip(node.Block); err != nil {
+			bc.Index.Invalidate(node)
+
+			// Restore the previous main chain
+			restored := disconnected
+			for range branch[:i] {
+				disconnected = append(disconnected, bc.disconnectTip())
+			}
+			for j := len(restored) - 1; j >= 0; j-- {
+				if err := bc.connectTip(restored[j]); err != nil {
+					log.Printf("Failed to restore block %s: %v\n", restored[j].BlockID, err)
+				}
 			}
+			bc.revalidateMempool(disconnected)
+			return fmt.Errorf("invalid block %s: %v", node.BlockID, err)
 		}
 	}
+
+	if len(disconnected) > 0 {
+		log.Printf("Reorganized the chain: disconnected %d blocks and connected %d blocks\n", len(disconnected), len(branch))
+		bc.revalidateMempool(disconnected)
+	}
 	return nil
 }
 
-// RemoveDivergingBlocks removes blocks that diverge from the last common block
-func (bc *Blockchain) RemoveDivergingBlocks(lca *block.Block) {
-	for i := len(bc.Blocks) - 1; i >= 0; i-- {
-		if lca != nil && bc.Blocks[i].BlockID == lca.BlockID {
-			break
+// revalidateMempool validates the transactions of the disconnected blocks and
+// the pending transactions against the tip, and keeps the valid ones in the
+// mempool. Transactions conflicting with the main chain or with each other,
+// spending missing outputs or no longer affordable are dropped.
+func (bc *Blockchain) revalidateMempool(disconnected []*block.Block) {
+	// The transactions of the disconnected blocks come first, oldest block
+	// first, as they were confirmed before the pending ones. The ones
+	// confirmed again by the new main chain are left out.
+	candidates := make([]*transaction.Transaction, 0)
+	for i := len(disconnected) - 1; i >= 0; i-- {
+		for _, tx := range disconnected[i].Transactions[1:] {
+			if _, ok := bc.TxIndex[tx.TransactionID]; !ok {
+				candidates = append(candidates, tx)
+			}
 		}
-
-		// Add transactions back to the mempool
-		for _, tx := range bc.Blocks[i].Transactions[1:] {
-			bc.Mempool.AddTransaction(tx)
+	}
+	candidates = append(candidates, bc.Mempool.RemoveAllTransactions()...)
+
+	// Accept the transactions until no more can be, since a transaction may
+	// spend the outputs of a transaction placed after it
+	total := len(candidates)
+	for accepted := true; accepted; {
+		accepted = false
+		remaining := make([]*transaction.Transaction, 0, len(candidates))
+		for _, tx := range candidates {
+			if bc.validateTransaction(tx) != nil || bc.Mempool.AddTransaction(tx) != nil {
+				remaining = append(remaining, tx)
+				continue
+			}
+			accepted = true
 		}
+		candidates = remaining
+	}
 
-		// Remove the block
-		bc.Blocks = bc.Blocks[:len(bc.Blocks)-1]
+	if len(candidates) > 0 {
+		log.Printf("Dropped %d of %d transactions from the mempool after the chain switch\n", len(candidates), total)
 	}
 }
 
-// AppendBlocks appends new blocks to the blockchain
-func (bc *Blockchain) AppendForkBlocks(blocks []*block.Block, lca *block.Block) {
-	for i := 0; i < len(blocks); i++ {
-		if lca != nil && blocks[i].BlockID != lca.BlockID {
-			continue
-		}
+// connectTip validates a block extending the tip and connects it to the main chain
+func (bc *Blockchain) connectTip(b *block.Block) error {
+	if err := bc.ValidateNewBlock(b); err != nil {
+		return err
+	}
 
-		start := i + 1
-		if lca == nil {
-			start = 0
-		}
+	bc.Blocks = append(bc.Blocks, b)
+	bc.CumulativePoW.Add(bc.CumulativePoW, block.CalcWork(b.Bits))
+	bc.connectBlock(b, len(bc.Blocks)-1)
+	bc.persistTip()
 
-		// Append the rest of the blocks
-		for j := start; j < len(blocks); j++ {
-			bc.Blocks = append(bc.Blocks, blocks[j])
+	// Remove the transactions of the block from the mempool
+	bc.Mempool.RemoveTransactionsInBlock(b)
 
-			// Remove transactions from the mempool
-			for _, tx := range blocks[j].Transactions {
-				bc.Mempool.RemoveTransaction(tx.TransactionID)
-			}
-		}
+	bc.Events.Publish(&Event{Type: BLOCKCONNECTED, Block: b, Height: len(bc.Blocks) - 1})
+	return nil
+}
+
+// disconnectTip disconnects the tip from the main chain and returns the block.
+// Its transactions are returned to the mempool by revalidateMempool.
+func (bc *Blockchain) disconnectTip() *block.Block {
+	tip := bc.GetLatestBlock()
 
-		return
+	// Revert the block from the UTXO set and the transaction index
+	bc.disconnectBlock(tip)
+	bc.CumulativePoW.Sub(bc.CumulativePoW, block.CalcWork(tip.Bits))
+	bc.Blocks = bc.Blocks[:len(bc.Blocks)-1]
+
+	bc.Events.Publish(&Event{Type: BLOCKDISCONNECTED, Block: tip, Height: len(bc.Blocks)})
+	return tip
+}
+
+// branchBlocks returns the blocks from the genesis block up to the given node
+func (bc *Blockchain) branchBlocks(node *BlockNode) []*block.Block {
+	branch := make([]*block.Block, 0)
+	for ; !bc.isMainChain(node); node = node.Parent {
+		branch = append(branch, node.Block)
 	}
+
+	blocks := append([]*block.Block{}, bc.Blocks[:node.Height+1]...)
+	for i := len(branch) - 1; i >= 0; i-- {
+		blocks = append(blocks, branch[i])
+	}
+	return blocks
+}
+
+// isMainChain checks if the node is part of the main chain
+func (bc *Blockchain) isMainChain(node *BlockNode) bool {
+	return node.Height < len(bc.Blocks) && bc.Blocks[node.Height].BlockID == node.BlockID
 }
@@ -0,0 +1,70 @@
+package blockchain
+
+import (
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/utils"
+)
+
+const (
+	MINTIMESAMPLES    = 5       // Number of peers needed before the local clock is adjusted
+	MAXTIMESAMPLES    = 200     // Maximum number of peers whose clock offset is tracked
+	MAXTIMEADJUSTMENT = 70 * 60 // Largest offset in seconds applied to the local clock
+)
+
+type MedianTimeSource struct {
+	Offsets map[string]int64 // Peer address -> Offset of the peer clock from the local clock in seconds
+	mutex   *sync.RWMutex    // Mutex to protect the offsets
+}
+
+// NewMedianTimeSource creates a time source without any peer sample
+func NewMedianTimeSource() *MedianTimeSource {
+	return &MedianTimeSource{
+		Offsets: make(map[string]int64),
+		mutex:   &sync.RWMutex{},
+	}
+}
+
+// AddTimeSample records the clock offset of a peer from a timestamp it has just sent
+func (ts *MedianTimeSource) AddTimeSample(peer string, timestamp int64) {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+
+	if _, ok := ts.Offsets[peer]; !ok && len(ts.Offsets) >= MAXTIMESAMPLES {
+		return
+	}
+	ts.Offsets[peer] = timestamp - utils.GetCurrentTimeInUnix()
+}
+
+// Offset returns the median of the peer clock offsets and the local clock.
+// The local clock is kept as is until enough peers reported their time, or
+// if the median offset is implausibly large.
+func (ts *MedianTimeSource) Offset() int64 {
+	ts.mutex.RLock()
+	defer ts.mutex.RUnlock()
+
+	if len(ts.Offsets) < MINTIMESAMPLES {
+		return 0
+	}
+
+	// Sort the offsets, counting the local clock as an offset of 0
+	offsets := []int64{0}
+	for _, offset := range ts.Offsets {
+		offsets = append(offsets, offset)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+
+	median := offsets[len(offsets)/2]
+	if median > MAXTIMEADJUSTMENT || median < -MAXTIMEADJUSTMENT {
+		log.Printf("Ignoring a median peer clock offset of %d seconds, please check the local clock\n", median)
+		return 0
+	}
+	return median
+}
+
+// AdjustedTime returns the local time corrected by the median peer clock offset
+func (ts *MedianTimeSource) AdjustedTime() int64 {
+	return utils.GetCurrentTimeInUnix() + ts.Offset()
+}
@@ -0,0 +1,58 @@
+package blockchain
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/block"
+)
+
+const MEDIANTIMEBLOCKS = 11 // Number of blocks the median time past is computed over
+
+// CalculateMedianTimePast returns the median timestamp of the last
+// MEDIANTIMEBLOCKS blocks of the given chain
+func CalculateMedianTimePast(blocks []*block.Block) int64 {
+	start := max(0, len(blocks)-MEDIANTIMEBLOCKS)
+
+	timestamps := make([]int64, 0, MEDIANTIMEBLOCKS)
+	for _, b := range blocks[start:] {
+		timestamps = append(timestamps, b.Timestamp)
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+
+	return timestamps[len(timestamps)/2]
+}
+
+// NextBlockTimestamp returns the timestamp of a block extending the tip: the
+// adjusted network time, moved past the median time past if needed
+func (bc *Blockchain) NextBlockTimestamp() int64 {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+
+	return bc.nextBlockTimestamp()
+}
+
+// nextBlockTimestamp returns the timestamp of a block extending the tip
+func (bc *Blockchain) nextBlockTimestamp() int64 {
+	return max(bc.TimeSource.AdjustedTime(), CalculateMedianTimePast(bc.Blocks)+1)
+}
+
+// validateTimestamp validates that the timestamp of the block is past the
+// median time past of the chain below it and not too far in the future
+func (bc *Blockchain) validateTimestamp(b *block.Block, prevBlocks []*block.Block) error {
+	if len(prevBlocks) == 0 {
+		return nil
+	}
+
+	medianTimePast := CalculateMedianTimePast(prevBlocks)
+	if b.Timestamp <= medianTimePast {
+		return fmt.Errorf("block timestamp %d is not past the median time past %d", b.Timestamp, medianTimePast)
+	}
+
+	maxTimestamp := bc.TimeSource.AdjustedTime() + bc.Params.MaxFutureBlockTime
+	if b.Timestamp > maxTimestamp {
+		return fmt.Errorf("block timestamp %d is too far in the future", b.Timestamp)
+	}
+
+	return nil
+}
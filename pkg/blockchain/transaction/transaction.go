@@ -3,28 +3,58 @@ package transaction
 import (
 	"encoding/json"
 	"fmt"
-	"sort"
 
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/amount"
 	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/utils"
 )
 
+const COINBASE = "coinbase"
+
+type TxInput struct {
+	TxID        string `json:"txid"`         // ID of the transaction holding the spent output
+	OutputIndex int    `json:"output_index"` // Index of the spent output in that transaction
+}
+
+type TxOutput struct {
+	Value   amount.Amount `json:"value"`   // Value of the output in base units
+	Address string        `json:"address"` // Locking key (address allowed to spend the output)
+}
+
 type Transaction struct {
-	TransactionID string  `json:"transaction_id"`
-	Sender        string  `json:"sender"`
-	Recipient     string  `json:"recipient"`
-	Amount        float64 `json:"amount"`
-	Fee           float64 `json:"fee"`
-	Timestamp     int64   `json:"timestamp"`
-	Signature     string  `json:"signature"`
+	TransactionID string        `json:"transaction_id"`
+	Sender        string        `json:"sender"`
+	Inputs        []*TxInput    `json:"inputs"`
+	Outputs       []*TxOutput   `json:"outputs"`
+	Fee           amount.Amount `json:"fee"`
+	Timestamp     int64         `json:"timestamp"`
+	Height        int           `json:"height,omitempty"`      // Height of the block holding the coinbase (coinbase only)
+	ExtraNonce    uint64        `json:"extra_nonce,omitempty"` // Nonce searched once the header nonces are exhausted (coinbase only)
+	Signature     string        `json:"signature"`
+}
+
+// NewTxInput creates a new input spending the given output
+func NewTxInput(txID string, outputIndex int) *TxInput {
+	return &TxInput{
+		TxID:        txID,
+		OutputIndex: outputIndex,
+	}
+}
+
+// NewTxOutput creates a new output locked to the given address
+func NewTxOutput(value amount.Amount, address string) *TxOutput {
+	return &TxOutput{
+		Value:   value,
+		Address: address,
+	}
 }
 
 // NewUnsignedTransaction creates a new unsigned transaction
-func NewUnsignedTransaction(sender, recipient string, amount, fee float64) *Transaction {
+func NewUnsignedTransaction(sender string, inputs []*TxInput, outputs []*TxOutput, fee amount.Amount) *Transaction {
 	// Create a new transaction
 	tx := Transaction{
 		Sender:    sender,
-		Recipient: recipient,
-		Amount:    amount,
+		Inputs:    inputs,
+		Outputs:   outputs,
 		Fee:       fee,
 		Timestamp: utils.GetCurrentTimeInUnix(),
 	}
@@ -35,17 +65,46 @@ func NewUnsignedTransaction(sender, recipient string, amount, fee float64) *Tran
 	return &tx
 }
 
-func NewCoinbaseTransaction(miner string, reward float64) *Transaction {
+// NewCoinbaseTransaction creates the coinbase of the block at the given height.
+// Committing to the height keeps the IDs of coinbases paying the same miner unique.
+func NewCoinbaseTransaction(miner string, reward amount.Amount, height int) *Transaction {
 	// Create a new transaction
-	tx := NewUnsignedTransaction("coinbase", miner, reward, 0)
+	outputs := []*TxOutput{NewTxOutput(reward, miner)}
+	tx := NewUnsignedTransaction(COINBASE, nil, outputs, 0)
+	tx.Height = height
+	tx.TransactionID = tx.GenerateTransactionID()
 
 	return tx
 }
 
-// GenerateTransactionID generates a unique ID for the transaction
+// IsCoinbase checks if the transaction is a coinbase transaction
+func (tx *Transaction) IsCoinbase() bool {
+	return tx.Sender == COINBASE
+}
+
+// TotalOutput returns the sum of the output values, or an error if it overflows
+func (tx *Transaction) TotalOutput() (amount.Amount, error) {
+	total := amount.Amount(0)
+	for _, output := range tx.Outputs {
+		var err error
+		if total, err = total.Add(output.Value); err != nil {
+			return 0, err
+		}
+	}
+	return total, nil
+}
+
+// GenerateTransactionID generates a unique ID for the transaction, committing
+// to its signature
 func (tx *Transaction) GenerateTransactionID() string {
-	data := fmt.Sprintf("%s%s%f%f%d%s", tx.Sender, tx.Recipient, tx.Amount, tx.Fee, tx.Timestamp, tx.Signature)
-	return utils.Hash(data)
+	data, err := json.Marshal(&struct {
+		*signedFields
+		Signature string `json:"signature"`
+	}{tx.signedFields(), tx.Signature})
+	if err != nil {
+		return ""
+	}
+	return utils.Hash(string(data))
 }
 
 // Hash generates the hash of the transaction
@@ -54,9 +113,39 @@ func (tx *Transaction) Hash() string {
 	return utils.Hash(data)
 }
 
-// GenerateDataForSigning generates the data that needs to be signed
+// signedFields are the fields of a transaction covered by its signature
+type signedFields struct {
+	Sender     string        `json:"sender"`
+	Inputs     []*TxInput    `json:"inputs"`
+	Outputs    []*TxOutput   `json:"outputs"`
+	Fee        amount.Amount `json:"fee"`
+	Timestamp  int64         `json:"timestamp"`
+	Height     int           `json:"height"`
+	ExtraNonce uint64        `json:"extra_nonce"`
+}
+
+// signedFields returns the fields of the transaction covered by its signature
+func (tx *Transaction) signedFields() *signedFields {
+	return &signedFields{
+		Sender:     tx.Sender,
+		Inputs:     tx.Inputs,
+		Outputs:    tx.Outputs,
+		Fee:        tx.Fee,
+		Timestamp:  tx.Timestamp,
+		Height:     tx.Height,
+		ExtraNonce: tx.ExtraNonce,
+	}
+}
+
+// GenerateDataForSigning generates the data that needs to be signed: the
+// JSON encoding of the signed fields, which delimits every field so that
+// no two different transactions share it
 func (tx *Transaction) GenerateDataForSigning() string {
-	return fmt.Sprintf("%s%s%f%f%d", tx.Sender, tx.Recipient, tx.Amount, tx.Fee, tx.Timestamp)
+	data, err := json.Marshal(tx.signedFields())
+	if err != nil {
+		return ""
+	}
+	return string(data)
 }
 
 // Serialize serializes the transaction into a string
@@ -78,9 +167,29 @@ func DeserializeTransaction(data string) (*Transaction, error) {
 	return &tx, nil
 }
 
-// SortTransactionsByFee sorts the transactions by fee
-func SortTransactionsByFee(transactions []*Transaction) {
-	sort.Slice(transactions, func(i, j int) bool {
-		return transactions[i].Fee > transactions[j].Fee
-	})
+// Size returns the size of the serialized transaction in bytes
+func (tx *Transaction) Size() int {
+	data, err := json.Marshal(tx)
+	if err != nil {
+		return 0
+	}
+	return len(data)
+}
+
+// SigOpCost returns the validation cost of the transaction: one signature
+// check, plus one ownership check per input. A coinbase costs nothing.
+func (tx *Transaction) SigOpCost() int {
+	if tx.IsCoinbase() {
+		return 0
+	}
+	return 1 + len(tx.Inputs)
+}
+
+// FeeRate returns the fee paid per byte of the serialized transaction
+func (tx *Transaction) FeeRate() amount.Amount {
+	size := tx.Size()
+	if size == 0 {
+		return 0
+	}
+	return tx.Fee / amount.Amount(size)
 }
@@ -0,0 +1,104 @@
+package transaction
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/amount"
+)
+
+// testTransaction creates a transaction from fixed fields
+func testTransaction(inputs []*TxInput, outputs []*TxOutput, fee amount.Amount) *Transaction {
+	tx := &Transaction{
+		Sender:    strings.Repeat("ab", 64),
+		Inputs:    inputs,
+		Outputs:   outputs,
+		Fee:       fee,
+		Timestamp: 1700000000,
+	}
+	tx.TransactionID = tx.GenerateTransactionID()
+	return tx
+}
+
+func TestTransactionIDsDiffer(t *testing.T) {
+	txID := strings.Repeat("cd", 32)
+	address := strings.Repeat("ef", 64)
+
+	tests := []struct {
+		name string
+		a, b *Transaction
+	}{
+		{
+			name: "input index shifted into the output value",
+			a:    testTransaction([]*TxInput{NewTxInput(txID, 1)}, []*TxOutput{NewTxOutput(23, address)}, 0),
+			b:    testTransaction([]*TxInput{NewTxInput(txID, 12)}, []*TxOutput{NewTxOutput(3, address)}, 0),
+		},
+		{
+			name: "address digit shifted into the fee",
+			a:    testTransaction([]*TxInput{NewTxInput(txID, 0)}, []*TxOutput{NewTxOutput(10, address+"1")}, 5),
+			b:    testTransaction([]*TxInput{NewTxInput(txID, 0)}, []*TxOutput{NewTxOutput(10, address)}, 15),
+		},
+		{
+			name: "input split across two inputs",
+			a:    testTransaction([]*TxInput{NewTxInput(txID, 11)}, []*TxOutput{NewTxOutput(10, address)}, 0),
+			b:    testTransaction([]*TxInput{NewTxInput(txID, 1), NewTxInput("1", 0)}, []*TxOutput{NewTxOutput(10, address)}, 0),
+		},
+		{
+			name: "output value and fee swapped",
+			a:    testTransaction([]*TxInput{NewTxInput(txID, 0)}, []*TxOutput{NewTxOutput(10, address)}, 20),
+			b:    testTransaction([]*TxInput{NewTxInput(txID, 0)}, []*TxOutput{NewTxOutput(20, address)}, 10),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.a.GenerateDataForSigning() == tt.b.GenerateDataForSigning() {
+				t.Errorf("different transactions share the signed data %s", tt.a.GenerateDataForSigning())
+			}
+			if tt.a.TransactionID == tt.b.TransactionID {
+				t.Errorf("different transactions share the ID %s", tt.a.TransactionID)
+			}
+		})
+	}
+}
+
+func TestTransactionIDCommitsToSignature(t *testing.T) {
+	tx := testTransaction([]*TxInput{NewTxInput(strings.Repeat("cd", 32), 0)}, []*TxOutput{NewTxOutput(10, strings.Repeat("ef", 64))}, 1)
+	signed := *tx
+	signed.Signature = strings.Repeat("01", 64)
+
+	if tx.Hash() != signed.Hash() {
+		t.Errorf("the signature changed the signed data")
+	}
+	if tx.GenerateTransactionID() == signed.GenerateTransactionID() {
+		t.Errorf("the transaction ID does not commit to the signature")
+	}
+}
+
+func TestValidateOutputs(t *testing.T) {
+	// A point on P256: the generator
+	valid := "6b17d1f2e12c4247f8bce6e563a440f277037d812deb33a0f4a13945d898c296" +
+		"4fe342e2fe1a7f9b8ee7eb4a7c0f9e162bce33576b315ececbb6406837bf51f5"
+
+	tests := []struct {
+		name    string
+		outputs []*TxOutput
+		wantErr bool
+	}{
+		{name: "valid address", outputs: []*TxOutput{NewTxOutput(10, valid)}, wantErr: false},
+		{name: "no outputs", outputs: nil, wantErr: true},
+		{name: "zero value", outputs: []*TxOutput{NewTxOutput(0, valid)}, wantErr: true},
+		{name: "empty address", outputs: []*TxOutput{NewTxOutput(10, "")}, wantErr: true},
+		{name: "address with an extra digit", outputs: []*TxOutput{NewTxOutput(10, valid+"1")}, wantErr: true},
+		{name: "address off the curve", outputs: []*TxOutput{NewTxOutput(10, strings.Repeat("ef", 64))}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := &Transaction{Outputs: tt.outputs}
+			if err := tx.validateOutputs(); (err != nil) != tt.wantErr {
+				t.Errorf("validateOutputs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -18,13 +18,21 @@ func (tx *Transaction) Validate() error {
 		return err
 	}
 
-	// Check if the recipient is valid
-	if err := tx.validateRecipient(); err != nil {
+	// Check that the transaction does not carry a coinbase height or extra nonce
+	if tx.Height != 0 {
+		return fmt.Errorf("only a coinbase may commit to a block height")
+	}
+	if tx.ExtraNonce != 0 {
+		return fmt.Errorf("only a coinbase may carry an extra nonce")
+	}
+
+	// Check if the inputs are valid
+	if err := tx.validateInputs(); err != nil {
 		return err
 	}
 
-	// Check if the amount is valid
-	if err := tx.validateAmount(); err != nil {
+	// Check if the outputs are valid
+	if err := tx.validateOutputs(); err != nil {
 		return err
 	}
 
@@ -54,22 +62,51 @@ func (tx *Transaction) validateTransactionID() error {
 	return nil
 }
 
-// TODO: Implement validateSender
-// validateSender checks if the sender is valid
+// validateSender checks if the sender is valid. The coinbase sender is
+// reserved for the first transaction of a block.
 func (tx *Transaction) validateSender() error {
+	if tx.IsCoinbase() {
+		return fmt.Errorf("sender %q is reserved for the coinbase", COINBASE)
+	}
 	return nil
 }
 
-// TODO: Implement validateRecipient
-// validateRecipient checks if the recipient is valid
-func (tx *Transaction) validateRecipient() error {
+// validateInputs checks if the inputs are valid
+func (tx *Transaction) validateInputs() error {
+	if len(tx.Inputs) == 0 {
+		return fmt.Errorf("transaction must have at least one input")
+	}
+
+	// Check that no output is spent twice by the same transaction
+	seen := make(map[string]bool)
+	for _, input := range tx.Inputs {
+		key := fmt.Sprintf("%s:%d", input.TxID, input.OutputIndex)
+		if seen[key] {
+			return fmt.Errorf("duplicate input: %s", key)
+		}
+		seen[key] = true
+	}
 	return nil
 }
 
-// validateAmount checks if the amount is valid
-func (tx *Transaction) validateAmount() error {
-	if tx.Amount < 0 {
-		return fmt.Errorf("amount must be greater than or equal to 0")
+// validateOutputs checks if the outputs are valid
+func (tx *Transaction) validateOutputs() error {
+	if len(tx.Outputs) == 0 {
+		return fmt.Errorf("transaction must have at least one output")
+	}
+
+	for i, output := range tx.Outputs {
+		if output.Value <= 0 {
+			return fmt.Errorf("output %d must have a value greater than 0", i)
+		}
+		if err := utils.ValidateAddress(output.Address); err != nil {
+			return fmt.Errorf("output %d: %v", i, err)
+		}
+	}
+
+	// Check that the total output does not overflow
+	if _, err := tx.TotalOutput(); err != nil {
+		return err
 	}
 	return nil
 }
@@ -102,3 +139,48 @@ func (tx *Transaction) validateSignature() error {
 	}
 	return nil
 }
+
+// ValidateLimits checks that the transaction fits within the given size and
+// sigop cost limits
+func (tx *Transaction) ValidateLimits(maxSize, maxSigOpCost int) error {
+	if size := tx.Size(); size > maxSize {
+		return fmt.Errorf("transaction size %d exceeds the limit of %d bytes", size, maxSize)
+	}
+	if cost := tx.SigOpCost(); cost > maxSigOpCost {
+		return fmt.Errorf("transaction sigop cost %d exceeds the limit of %d", cost, maxSigOpCost)
+	}
+	return nil
+}
+
+// ValidateCoinbase checks if the transaction is a well-formed coinbase. The
+// reward and the committed height are checked against the chain.
+func (tx *Transaction) ValidateCoinbase() error {
+	if !tx.IsCoinbase() {
+		return fmt.Errorf("sender %q is not the coinbase", tx.Sender)
+	}
+
+	// Check if the transaction ID is valid
+	if err := tx.validateTransactionID(); err != nil {
+		return err
+	}
+
+	// A coinbase creates new coins without spending any output
+	if len(tx.Inputs) != 0 {
+		return fmt.Errorf("coinbase must not have inputs")
+	}
+
+	// Check if the outputs are valid
+	if err := tx.validateOutputs(); err != nil {
+		return err
+	}
+
+	// A coinbase collects the fees but pays none and is not signed
+	if tx.Fee != 0 {
+		return fmt.Errorf("coinbase must not pay a fee")
+	}
+	if tx.Signature != "" {
+		return fmt.Errorf("coinbase must not be signed")
+	}
+
+	return nil
+}
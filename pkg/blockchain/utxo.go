@@ -0,0 +1,98 @@
+package blockchain
+
+import (
+	"log"
+
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/amount"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/block"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/transaction"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/utxo"
+)
+
+// GetUTXOs returns the unspent outputs locked to an address
+func (bc *Blockchain) GetUTXOs(address string) []*utxo.UTXO {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+
+	return bc.UTXOSet.GetUTXOsByAddress(address)
+}
+
+// GetSpendableUTXOs returns the unspent outputs locked to an address that can
+// be spent in the next block, leaving out immature coinbase outputs
+func (bc *Blockchain) GetSpendableUTXOs(address string) []*utxo.UTXO {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+
+	utxos := make([]*utxo.UTXO, 0)
+	for _, u := range bc.UTXOSet.GetUTXOsByAddress(address) {
+		if !bc.isImmatureCoinbase(u.OutPoint.TxID, len(bc.Blocks)) {
+			utxos = append(utxos, u)
+		}
+	}
+	return utxos
+}
+
+// GetBalance returns the balance of an address
+func (bc *Blockchain) GetBalance(address string) amount.Amount {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+
+	return bc.UTXOSet.GetBalance(address)
+}
+
+// BlockUndo holds the outputs spent by each transaction of a connected block,
+// so that the block can be disconnected again
+type BlockUndo [][]*utxo.UTXO
+
+// connectBlock applies the transactions of the block at the given height to
+// the UTXO set and the transaction index, and records the undo data of the block
+func (bc *Blockchain) connectBlock(b *block.Block, height int) {
+	undo := make(BlockUndo, len(b.Transactions))
+	for i, tx := range b.Transactions {
+		undo[i] = bc.UTXOSet.ApplyTransaction(tx)
+		bc.TxIndex[tx.TransactionID] = height
+	}
+	bc.Undo[b.BlockID] = undo
+}
+
+// disconnectBlock reverts the transactions of a block from the UTXO set and
+// the transaction index using the undo data of the block
+func (bc *Blockchain) disconnectBlock(b *block.Block) {
+	undo, ok := bc.Undo[b.BlockID]
+	if !ok {
+		log.Printf("Missing undo data for block %s\n", b.BlockID)
+	}
+
+	for i := len(b.Transactions) - 1; i >= 0; i-- {
+		tx := b.Transactions[i]
+
+		// Remove the outputs created by the transaction
+		bc.UTXOSet.RemoveTransactionOutputs(tx)
+
+		// Restore the outputs spent by the transaction
+		if i < len(undo) {
+			for _, spent := range undo[i] {
+				bc.UTXOSet.Add(spent.OutPoint, spent.Output)
+			}
+		}
+
+		delete(bc.TxIndex, tx.TransactionID)
+	}
+	delete(bc.Undo, b.BlockID)
+}
+
+// findTransaction finds a confirmed transaction and the height of its block
+// through the transaction index
+func (bc *Blockchain) findTransaction(txID string) (*transaction.Transaction, int) {
+	height, ok := bc.TxIndex[txID]
+	if !ok || height >= len(bc.Blocks) {
+		return nil, -1
+	}
+
+	for _, tx := range bc.Blocks[height].Transactions {
+		if tx.TransactionID == txID {
+			return tx, height
+		}
+	}
+	return nil, -1
+}
@@ -0,0 +1,148 @@
+package utxo
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/amount"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/transaction"
+)
+
+type OutPoint struct {
+	TxID  string `json:"txid"`  // ID of the transaction holding the output
+	Index int    `json:"index"` // Index of the output in the transaction
+}
+
+type UTXO struct {
+	OutPoint OutPoint              `json:"outpoint"` // Location of the output
+	Output   *transaction.TxOutput `json:"output"`   // Unspent output
+}
+
+type UTXOSet struct {
+	UTXOs        map[OutPoint]*transaction.TxOutput // OutPoint -> Unspent output
+	AddressIndex map[string]map[OutPoint]bool       // Address -> OutPoints locked to the address
+}
+
+// NewOutPoint creates a new outpoint
+func NewOutPoint(txID string, index int) OutPoint {
+	return OutPoint{
+		TxID:  txID,
+		Index: index,
+	}
+}
+
+// NewOutPointFromInput creates the outpoint referenced by a transaction input
+func NewOutPointFromInput(input *transaction.TxInput) OutPoint {
+	return NewOutPoint(input.TxID, input.OutputIndex)
+}
+
+// String returns the outpoint as "txid:index"
+func (op OutPoint) String() string {
+	return fmt.Sprintf("%s:%d", op.TxID, op.Index)
+}
+
+// NewUTXOSet creates an empty UTXO set
+func NewUTXOSet() *UTXOSet {
+	return &UTXOSet{
+		UTXOs:        make(map[OutPoint]*transaction.TxOutput),
+		AddressIndex: make(map[string]map[OutPoint]bool),
+	}
+}
+
+// Get returns the unspent output at the outpoint
+func (set *UTXOSet) Get(op OutPoint) (*transaction.TxOutput, bool) {
+	output, ok := set.UTXOs[op]
+	return output, ok
+}
+
+// Add adds an unspent output to the set
+func (set *UTXOSet) Add(op OutPoint, output *transaction.TxOutput) {
+	set.UTXOs[op] = output
+
+	if set.AddressIndex[output.Address] == nil {
+		set.AddressIndex[output.Address] = make(map[OutPoint]bool)
+	}
+	set.AddressIndex[output.Address][op] = true
+}
+
+// Remove removes an output from the set
+func (set *UTXOSet) Remove(op OutPoint) {
+	output, ok := set.UTXOs[op]
+	if !ok {
+		return
+	}
+	delete(set.UTXOs, op)
+
+	delete(set.AddressIndex[output.Address], op)
+	if len(set.AddressIndex[output.Address]) == 0 {
+		delete(set.AddressIndex, output.Address)
+	}
+}
+
+// AddTransactionOutputs adds all outputs of a transaction to the set
+func (set *UTXOSet) AddTransactionOutputs(tx *transaction.Transaction) {
+	for i, output := range tx.Outputs {
+		set.Add(NewOutPoint(tx.TransactionID, i), output)
+	}
+}
+
+// RemoveTransactionOutputs removes all outputs of a transaction from the set
+func (set *UTXOSet) RemoveTransactionOutputs(tx *transaction.Transaction) {
+	for i := range tx.Outputs {
+		set.Remove(NewOutPoint(tx.TransactionID, i))
+	}
+}
+
+// ApplyTransaction spends the inputs and adds the outputs of a transaction,
+// returning the spent outputs
+func (set *UTXOSet) ApplyTransaction(tx *transaction.Transaction) []*UTXO {
+	spent := make([]*UTXO, 0, len(tx.Inputs))
+	for _, input := range tx.Inputs {
+		op := NewOutPointFromInput(input)
+		if output, ok := set.Get(op); ok {
+			spent = append(spent, &UTXO{OutPoint: op, Output: output})
+		}
+		set.Remove(op)
+	}
+
+	set.AddTransactionOutputs(tx)
+
+	return spent
+}
+
+// GetUTXOsByAddress returns the unspent outputs locked to an address
+func (set *UTXOSet) GetUTXOsByAddress(address string) []*UTXO {
+	utxos := make([]*UTXO, 0, len(set.AddressIndex[address]))
+	for op := range set.AddressIndex[address] {
+		utxos = append(utxos, &UTXO{OutPoint: op, Output: set.UTXOs[op]})
+	}
+	return utxos
+}
+
+// GetBalance returns the sum of the unspent outputs locked to an address
+func (set *UTXOSet) GetBalance(address string) amount.Amount {
+	balance := amount.Amount(0)
+	for op := range set.AddressIndex[address] {
+		balance += set.UTXOs[op].Value
+	}
+	return balance
+}
+
+// SerializeUTXOs serializes a list of UTXOs into a string
+func SerializeUTXOs(utxos []*UTXO) (string, error) {
+	data, err := json.Marshal(utxos)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize UTXOs: %v", err)
+	}
+	return string(data), nil
+}
+
+// DeserializeUTXOs deserializes a list of UTXOs from a string
+func DeserializeUTXOs(data string) ([]*UTXO, error) {
+	var utxos []*UTXO
+	err := json.Unmarshal([]byte(data), &utxos)
+	if err != nil {
+		return nil, fmt.Errorf("failed to deserialize UTXOs: %v", err)
+	}
+	return utxos, nil
+}
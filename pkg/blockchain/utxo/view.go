@@ -0,0 +1,42 @@
+package utxo
+
+import "github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/transaction"
+
+// View is a copy-on-write overlay on top of a UTXO set. It lets a block be
+// validated transaction by transaction without touching the underlying set.
+type View struct {
+	Base  *UTXOSet                           // Underlying UTXO set
+	Added map[OutPoint]*transaction.TxOutput // Outputs created inside the view
+	Spent map[OutPoint]bool                  // Outputs spent inside the view
+}
+
+// NewView creates a new view on top of a UTXO set
+func NewView(base *UTXOSet) *View {
+	return &View{
+		Base:  base,
+		Added: make(map[OutPoint]*transaction.TxOutput),
+		Spent: make(map[OutPoint]bool),
+	}
+}
+
+// Get returns the unspent output at the outpoint as seen by the view
+func (v *View) Get(op OutPoint) (*transaction.TxOutput, bool) {
+	if v.Spent[op] {
+		return nil, false
+	}
+	if output, ok := v.Added[op]; ok {
+		return output, true
+	}
+	return v.Base.Get(op)
+}
+
+// ApplyTransaction spends the inputs and adds the outputs of a transaction
+func (v *View) ApplyTransaction(tx *transaction.Transaction) {
+	for _, input := range tx.Inputs {
+		v.Spent[NewOutPointFromInput(input)] = true
+	}
+
+	for i, output := range tx.Outputs {
+		v.Added[NewOutPoint(tx.TransactionID, i)] = output
+	}
+}
@@ -3,8 +3,10 @@ package blockchain
 import (
 	"fmt"
 
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/amount"
 	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/block"
 	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/transaction"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/utxo"
 )
 
 // Validate validates the blockchain
@@ -14,11 +16,17 @@ func (bc *Blockchain) Validate() error {
 		return err
 	}
 
-	// Validate the blocks
+	// Rebuild the UTXO set and the transaction index from the genesis block
+	// while validating the blocks
+	bc.UTXOSet = utxo.NewUTXOSet()
+	bc.TxIndex = make(map[string]int)
+	bc.Undo = make(map[string]BlockUndo)
+	bc.connectBlock(bc.Blocks[0], 0)
 	for i, b := range bc.Blocks[1:] {
 		if err := bc.ValidateBlock(b, i+1); err != nil {
 			return fmt.Errorf("invalid block: %v", err)
 		}
+		bc.connectBlock(b, i+1)
 	}
 
 	return nil
@@ -27,57 +35,86 @@ func (bc *Blockchain) Validate() error {
 // validateCumulativePoW validates the cumulative PoW
 func (bc *Blockchain) validateCumulativePoW() error {
 	cumulativePoW := bc.CalculateCumulativePoW()
-	if bc.CumulativePoW != cumulativePoW {
-		return fmt.Errorf("invalid cumulative PoW: %d", bc.CumulativePoW)
+	if bc.CumulativePoW == nil || bc.CumulativePoW.Cmp(cumulativePoW) != 0 {
+		return fmt.Errorf("invalid cumulative PoW: %v", bc.CumulativePoW)
 	}
 	return nil
 }
 
 // ValidateNewBlock validates the new block
 func (bc *Blockchain) ValidateNewBlock(b *block.Block) error {
-	// Validate the previous hash
-	height := len(bc.Blocks)
-	if err := bc.validatePrevHash(b, height); err != nil {
+	return bc.ValidateBlock(b, len(bc.Blocks))
+}
+
+// ValidateBlock validates the block
+func (bc *Blockchain) ValidateBlock(b *block.Block, height int) error {
+	// Validate the block against the chain
+	if err := bc.validateBlockContext(b, height); err != nil {
 		return err
 	}
 
-	// Validate the difficulty
-	if err := bc.validateDifficulty(b); err != nil {
+	// Validate the block
+	if err := b.Validate(bc.Params); err != nil {
 		return err
 	}
 
-	// Validate the reward
-	if err := bc.validateReward(b); err != nil {
+	return nil
+}
+
+// ValidateBlockTemplate validates a block extending the tip before its proof
+// of work is performed
+func (bc *Blockchain) ValidateBlockTemplate(b *block.Block) error {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+
+	// Validate the block against the chain
+	if err := bc.validateBlockContext(b, len(bc.Blocks)); err != nil {
 		return err
 	}
 
-	// Validate the block
-	if err := b.Validate(); err != nil {
+	// Validate the block, except for the proof of work
+	if err := b.ValidateTemplate(bc.Params); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-// ValidateBlock validates the block
-func (bc *Blockchain) ValidateBlock(b *block.Block, height int) error {
+// validateBlockContext validates the block at the given height against the
+// blocks below it and the UTXO set
+func (bc *Blockchain) validateBlockContext(b *block.Block, height int) error {
 	// Validate the previous hash
 	if err := bc.validatePrevHash(b, height); err != nil {
 		return err
 	}
 
-	// Validate the difficulty
-	if err := bc.validateDifficulty(b); err != nil {
+	// Validate the target
+	if err := bc.validateBits(b, bc.Blocks[:height]); err != nil {
+		return err
+	}
+
+	// Validate the timestamp
+	if err := bc.validateTimestamp(b, bc.Blocks[:height]); err != nil {
+		return err
+	}
+
+	// Validate the coinbase height
+	if err := validateCoinbaseHeight(b, height); err != nil {
 		return err
 	}
 
 	// Validate the reward
-	if err := bc.validateReward(b); err != nil {
+	if err := bc.validateReward(b, height); err != nil {
 		return err
 	}
 
-	// Validate the block
-	if err := b.Validate(); err != nil {
+	// Validate that no transaction is a duplicate or a replay
+	if err := bc.validateTransactionIDs(b); err != nil {
+		return err
+	}
+
+	// Validate the spent outputs
+	if err := bc.validateBlockUTXOs(b, height); err != nil {
 		return err
 	}
 
@@ -101,51 +138,189 @@ func (bc *Blockchain) validatePrevHash(b *block.Block, height int) error {
 	return nil
 }
 
-// validateDifficulty validates the difficulty
-func (bc *Blockchain) validateDifficulty(b *block.Block) error {
-	if b.Difficulty != bc.CalculateDifficulty() {
-		return fmt.Errorf("invalid difficulty: %d", b.Difficulty)
+// validateBits validates the PoW target against the chain below the block
+func (bc *Blockchain) validateBits(b *block.Block, prevBlocks []*block.Block) error {
+	if b.Bits != CalculateNextBits(bc.Params, prevBlocks) {
+		return fmt.Errorf("invalid target: %08x", b.Bits)
 	}
 	return nil
 }
 
-// validateReward validates the reward
-func (bc *Blockchain) validateReward(b *block.Block) error {
-	reward := bc.CalculateReward(b.Transactions)
+// validateReward validates that the coinbase pays the subsidy scheduled for
+// the height of the block plus the fees
+func (bc *Blockchain) validateReward(b *block.Block, height int) error {
+	reward, err := bc.CalculateReward(height, b.Transactions[1:])
+	if err != nil {
+		return err
+	}
+
 	coinbaseTx := b.Transactions[0]
-	if coinbaseTx.Amount != reward {
-		return fmt.Errorf("invalid reward: %f", coinbaseTx.Amount)
+	totalOutput, err := coinbaseTx.TotalOutput()
+	if err != nil {
+		return fmt.Errorf("invalid reward: %v", err)
+	}
+	if totalOutput != reward {
+		return fmt.Errorf("invalid reward: %s", totalOutput)
 	}
 
 	return nil
 }
 
+// validateCoinbaseHeight validates that the coinbase commits to the height of its block
+func validateCoinbaseHeight(b *block.Block, height int) error {
+	if len(b.Transactions) == 0 {
+		return fmt.Errorf("block has no coinbase")
+	}
+
+	coinbaseTx := b.Transactions[0]
+	if !coinbaseTx.IsCoinbase() || coinbaseTx.Height != height {
+		return fmt.Errorf("invalid coinbase height: %d", coinbaseTx.Height)
+	}
+	return nil
+}
+
+// validateTransactionIDs validates that the block neither contains the same
+// transaction twice nor replays a transaction confirmed in the chain
+func (bc *Blockchain) validateTransactionIDs(b *block.Block) error {
+	seen := make(map[string]bool)
+	for _, tx := range b.Transactions {
+		if seen[tx.TransactionID] {
+			return fmt.Errorf("duplicate transaction: %s", tx.TransactionID)
+		}
+		if _, ok := bc.TxIndex[tx.TransactionID]; ok {
+			return fmt.Errorf("transaction %s is already confirmed", tx.TransactionID)
+		}
+		seen[tx.TransactionID] = true
+	}
+	return nil
+}
+
+// validateBlockUTXOs validates that every transaction in the block spends
+// existing outputs, including outputs created earlier in the same block,
+// that no output is spent twice and that every spent coinbase is mature
+func (bc *Blockchain) validateBlockUTXOs(b *block.Block, height int) error {
+	view := utxo.NewView(bc.UTXOSet)
+	for _, tx := range b.Transactions {
+		// The outputs of the coinbase are immature within their own block
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		if err := validateUTXOs(view, tx); err != nil {
+			return fmt.Errorf("invalid transaction %s: %v", tx.TransactionID, err)
+		}
+		if err := bc.validateCoinbaseMaturity(tx, height); err != nil {
+			return fmt.Errorf("invalid transaction %s: %v", tx.TransactionID, err)
+		}
+		view.ApplyTransaction(tx)
+	}
+
+	return nil
+}
+
+// ValidateTransaction validates a transaction for the mempool against the
+// tip. The transaction may spend the outputs of pending transactions.
 func (bc *Blockchain) ValidateTransaction(tx *transaction.Transaction) error {
 	bc.mutex.RLock()
 	defer bc.mutex.RUnlock()
 
+	return bc.validateTransaction(tx)
+}
+
+func (bc *Blockchain) validateTransaction(tx *transaction.Transaction) error {
 	// Validate the transaction
 	if err := tx.Validate(); err != nil {
 		return err
 	}
 
-	// Validate the unspent transaction outputs
-	if err := bc.validateUTXOs(tx); err != nil {
+	// Validate that the transaction fits in a block
+	if err := tx.ValidateLimits(bc.Params.MaxTxSize, bc.Params.MaxBlockSigOpCost); err != nil {
+		return err
+	}
+
+	// Reject a replay of a confirmed transaction
+	if _, ok := bc.TxIndex[tx.TransactionID]; ok {
+		return fmt.Errorf("transaction %s is already confirmed", tx.TransactionID)
+	}
+
+	// Validate the unspent transaction outputs, including the outputs of
+	// pending transactions
+	view := utxo.NewView(bc.UTXOSet)
+	for _, input := range tx.Inputs {
+		op := utxo.NewOutPointFromInput(input)
+		if output, ok := bc.Mempool.GetOutput(op); ok {
+			view.Added[op] = output
+		}
+	}
+	if err := validateUTXOs(view, tx); err != nil {
+		return err
+	}
+
+	// Validate that the spent coinbases are mature in the next block
+	if err := bc.validateCoinbaseMaturity(tx, len(bc.Blocks)); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-// validateUTXOs validates the unspent transaction outputs
-func (bc *Blockchain) validateUTXOs(tx *transaction.Transaction) error {
-	// Get the unspent transaction outputs
-	utxos := bc.calculateUTXOs(tx.Sender)
+// validateUTXOs validates that the inputs of a transaction are unspent,
+// locked to the sender and add up to exactly the outputs plus the fee, so
+// that the declared fee is the fee the miner collects
+func validateUTXOs(view *utxo.View, tx *transaction.Transaction) error {
+	totalInput := amount.Amount(0)
+	for _, input := range tx.Inputs {
+		// Get the spent output
+		op := utxo.NewOutPointFromInput(input)
+		output, ok := view.Get(op)
+		if !ok {
+			return fmt.Errorf("missing or spent input: %s", op)
+		}
+
+		// Check the locking key
+		if output.Address != tx.Sender {
+			return fmt.Errorf("input %s is not owned by the sender", op)
+		}
+
+		var err error
+		if totalInput, err = totalInput.Add(output.Value); err != nil {
+			return fmt.Errorf("invalid input value: %v", err)
+		}
+	}
 
 	// Validate the sender's balance
-	if utxos < tx.Amount+tx.Fee {
-		return fmt.Errorf("insufficient balance: %f", utxos)
+	totalOutput, err := tx.TotalOutput()
+	if err != nil {
+		return err
+	}
+	totalSpent, err := totalOutput.Add(tx.Fee)
+	if err != nil {
+		return err
+	}
+	if totalInput < totalSpent {
+		return fmt.Errorf("insufficient input value: %s", totalInput)
+	}
+	if totalInput > totalSpent {
+		return fmt.Errorf("input value %s exceeds the outputs plus the fee %s", totalInput, totalSpent)
 	}
 
 	return nil
 }
+
+// validateCoinbaseMaturity validates that a transaction included at the given
+// height only spends coinbase outputs that are CoinbaseMaturity blocks deep
+func (bc *Blockchain) validateCoinbaseMaturity(tx *transaction.Transaction, height int) error {
+	for _, input := range tx.Inputs {
+		if bc.isImmatureCoinbase(input.TxID, height) {
+			return fmt.Errorf("input %s spends an immature coinbase", utxo.NewOutPointFromInput(input))
+		}
+	}
+	return nil
+}
+
+// isImmatureCoinbase checks if the transaction is a confirmed coinbase whose
+// outputs cannot be spent yet at the given height
+func (bc *Blockchain) isImmatureCoinbase(txID string, height int) bool {
+	tx, txHeight := bc.findTransaction(txID)
+	return tx != nil && tx.IsCoinbase() && height-txHeight < bc.Params.CoinbaseMaturity
+}
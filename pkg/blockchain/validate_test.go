@@ -0,0 +1,52 @@
+package blockchain_test
+
+import (
+	"testing"
+
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/amount"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/wallet"
+)
+
+func TestValidateTransactionBalance(t *testing.T) {
+	const coin = amount.Amount(100_000_000)
+
+	tests := []struct {
+		name        string
+		outputDelta amount.Amount // Change to the value of the output
+		feeDelta    amount.Amount // Change to the fee
+		wantErr     bool
+	}{
+		{name: "inputs equal outputs plus fee", wantErr: false},
+		{name: "fee moved to the output", outputDelta: 1000, feeDelta: -1000, wantErr: false},
+		{name: "inputs short of outputs plus fee by one unit", outputDelta: 1, wantErr: true},
+		{name: "inputs short of outputs plus fee by a coin", outputDelta: coin, wantErr: true},
+		{name: "fee exceeding the inputs left", feeDelta: 1, wantErr: true},
+		{name: "inputs exceed outputs plus fee by one unit", outputDelta: -1, wantErr: true},
+		{name: "inputs exceed outputs plus fee by a coin", outputDelta: -coin, wantErr: true},
+		{name: "fee short of the inputs left", feeDelta: -1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc := newTestBlockchain()
+			miner := wallet.NewWallet()
+			chain := extendChain(t, bc, bc.GetLatestBlock(), 1, testMaturity, miner.GetAddress())
+
+			// Spend the first coinbase, changing the output and the fee
+			tx := spendCoinbase(t, miner, chain[0])
+			tx.Outputs[0].Value += tt.outputDelta
+			tx.Fee += tt.feeDelta
+			signature, err := miner.Sign(tx.Hash())
+			if err != nil {
+				t.Fatalf("failed to sign transaction: %v", err)
+			}
+			tx.Signature = signature
+			tx.TransactionID = tx.GenerateTransactionID()
+
+			err = bc.ValidateTransaction(tx)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateTransaction() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package chaincfg
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/amount"
+)
+
+type Params struct {
+	Name        string  // Name of the network, selected with -network
+	Magic       [4]byte // Marks every frame; nodes on different networks cannot talk to each other
+	DefaultPort string  // Default P2P port
+	RPCPort     string  // Default JSON-RPC port
+
+	// Genesis block
+	GenesisTimestamp int64 // Timestamp of the genesis block, which makes each network's genesis unique
+
+	// Proof of work
+	PowLimitBits     uint32 // Easiest allowed target in compact form
+	InitialBits      uint32 // Target of the first mined block in compact form
+	TargetBlockTime  int64  // Target time between two blocks in seconds
+	RetargetInterval int    // Number of blocks between two difficulty adjustments
+	RetargetFactor   int64  // Maximum factor by which the target may change per adjustment
+	NoRetargeting    bool   // Keep the initial target forever
+
+	// Block timestamps
+	MaxFutureBlockTime int64 // Maximum number of seconds a block timestamp may be ahead of the adjusted network time
+
+	// Block limits
+	MaxBlockSize      int // Maximum size of a serialized block in bytes
+	MaxTxSize         int // Maximum size of a serialized transaction in bytes
+	MaxBlockSigOpCost int // Maximum total sigop cost of the transactions of a block
+
+	// Block subsidy
+	Subsidy          SubsidyParams // Subsidy schedule
+	CoinbaseMaturity int           // Number of blocks before the outputs of a coinbase can be spent
+
+	// Mining
+	BlockTransactions  int           // Maximum number of mempool transactions per block
+	MinerIdleInterval  time.Duration // Wait before checking an empty mempool again
+	MinerPauseInterval time.Duration // Pause after each mined block to let the network sync
+	MineOnDemand       bool          // Only mine when asked through the RPC "generate" method
+}
+
+// MainNetParams are the parameters of the main network
+var MainNetParams = Params{
+	Name:        "mainnet",
+	Magic:       [4]byte{0x53, 0x42, 0x54, 0x43}, // "SBTC"
+	DefaultPort: "8080",
+	RPCPort:     "8332",
+
+	GenesisTimestamp: 0,
+
+	PowLimitBits:     0x1f0fffff,
+	InitialBits:      0x1e0fffff,
+	TargetBlockTime:  60,
+	RetargetInterval: 10,
+	RetargetFactor:   4,
+
+	MaxFutureBlockTime: 2 * 60 * 60,
+
+	MaxBlockSize:      1_000_000,
+	MaxTxSize:         100_000,
+	MaxBlockSigOpCost: 20_000,
+
+	Subsidy: SubsidyParams{
+		InitialSubsidy:  1000 * amount.COIN,
+		HalvingInterval: 1000,
+		MaxSupply:       2_000_000 * amount.COIN,
+	},
+	CoinbaseMaturity: 100,
+
+	BlockTransactions:  10,
+	MinerIdleInterval:  20 * time.Second,
+	MinerPauseInterval: 60 * time.Second,
+}
+
+// TestNetParams are the parameters of the test network, with faster blocks
+var TestNetParams = Params{
+	Name:        "testnet",
+	Magic:       [4]byte{0x53, 0x42, 0x54, 0x54}, // "SBTT"
+	DefaultPort: "18080",
+	RPCPort:     "18332",
+
+	GenesisTimestamp: 1,
+
+	PowLimitBits:     0x1f0fffff,
+	InitialBits:      0x1f0fffff,
+	TargetBlockTime:  30,
+	RetargetInterval: 10,
+	RetargetFactor:   4,
+
+	MaxFutureBlockTime: 2 * 60 * 60,
+
+	MaxBlockSize:      1_000_000,
+	MaxTxSize:         100_000,
+	MaxBlockSigOpCost: 20_000,
+
+	Subsidy: SubsidyParams{
+		InitialSubsidy:  1000 * amount.COIN,
+		HalvingInterval: 1000,
+		MaxSupply:       2_000_000 * amount.COIN,
+	},
+	CoinbaseMaturity: 20,
+
+	BlockTransactions:  10,
+	MinerIdleInterval:  10 * time.Second,
+	MinerPauseInterval: 10 * time.Second,
+}
+
+// RegTestParams are the parameters of the regression test network: trivial
+// difficulty and blocks mined only on demand
+var RegTestParams = Params{
+	Name:        "regtest",
+	Magic:       [4]byte{0x53, 0x42, 0x54, 0x52}, // "SBTR"
+	DefaultPort: "18444",
+	RPCPort:     "18443",
+
+	GenesisTimestamp: 2,
+
+	PowLimitBits:     0x207fffff,
+	InitialBits:      0x207fffff,
+	TargetBlockTime:  1,
+	RetargetInterval: 10,
+	RetargetFactor:   4,
+	NoRetargeting:    true,
+
+	MaxFutureBlockTime: 2 * 60 * 60,
+
+	MaxBlockSize:      1_000_000,
+	MaxTxSize:         100_000,
+	MaxBlockSigOpCost: 20_000,
+
+	Subsidy: SubsidyParams{
+		InitialSubsidy:  50 * amount.COIN,
+		HalvingInterval: 150,
+		MaxSupply:       15_000 * amount.COIN,
+	},
+	CoinbaseMaturity: 100,
+
+	BlockTransactions:  100,
+	MinerIdleInterval:  time.Second,
+	MinerPauseInterval: 0,
+	MineOnDemand:       true,
+}
+
+// GetParams returns the parameters of the network with the given name
+func GetParams(name string) (*Params, error) {
+	for _, params := range []*Params{&MainNetParams, &TestNetParams, &RegTestParams} {
+		if params.Name == name {
+			return params, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown network: %s", name)
+}
@@ -0,0 +1,44 @@
+package chaincfg
+
+import "github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/amount"
+
+type SubsidyParams struct {
+	InitialSubsidy  amount.Amount `json:"initial_subsidy"`  // Subsidy of the blocks before the first halving
+	HalvingInterval int           `json:"halving_interval"` // Number of blocks between two halvings
+	MaxSupply       amount.Amount `json:"max_supply"`       // Upper bound on the total subsidy ever paid
+}
+
+// BlockSubsidy returns the subsidy of the block at the given height. The
+// subsidy halves every HalvingInterval blocks and stops once MaxSupply is reached.
+func (p *SubsidyParams) BlockSubsidy(height int) amount.Amount {
+	// The genesis block pays no subsidy
+	if height <= 0 {
+		return 0
+	}
+	return p.ScheduledSupply(height) - p.ScheduledSupply(height-1)
+}
+
+// ScheduledSupply returns the total subsidy of the blocks up to the given height
+func (p *SubsidyParams) ScheduledSupply(height int) amount.Amount {
+	supply := amount.Amount(0)
+	for start := 1; start <= height; {
+		// Sum the subsidy of one halving era at a time
+		end := min((start/p.HalvingInterval+1)*p.HalvingInterval-1, height)
+		subsidy := p.halvedSubsidy(start)
+		if subsidy == 0 {
+			break
+		}
+		supply += subsidy * amount.Amount(end-start+1)
+		start = end + 1
+	}
+	return min(supply, p.MaxSupply)
+}
+
+// halvedSubsidy returns the subsidy at the given height before applying the supply cap
+func (p *SubsidyParams) halvedSubsidy(height int) amount.Amount {
+	halvings := height / p.HalvingInterval
+	if halvings >= 63 {
+		return 0
+	}
+	return p.InitialSubsidy >> halvings
+}
@@ -13,8 +13,13 @@ const (
 	HEARTBEAT      = "HEARTBEAT"
 	NEWTRANSACTION = "NEWTRANSACTION"
 	NEWBLOCK       = "NEWBLOCK"
-	BLOCKCHAINREQ  = "BLOCKCHAINREQ"
-	BLOCKCHAINRESP = "BLOCKCHAINRESP"
+	GETHEADERS     = "GETHEADERS"
+	HEADERS        = "HEADERS"
+	GETBLOCKS      = "GETBLOCKS"
+	INV            = "INV"
+	BLOCK          = "BLOCK"
+	CHALLENGE      = "CHALLENGE"
+	CHALLENGERESP  = "CHALLENGERESP"
 )
 
 type Message struct {
@@ -23,6 +28,9 @@ type Message struct {
 	Receipient string `json:"receipient"` // Receipient of the message
 	Payload    string `json:"payload"`    // Payload of the message (as JSON string)
 	Timestamp  int64  `json:"timestamp"`  // Timestamp of the message
+	PublicKey  string `json:"public_key"` // Identity key of the sender
+	Signature  string `json:"signature"`  // Signature of the message by the identity key
+	Origin     string `json:"-"`          // Address of the connection the message arrived on
 }
 
 // NewMessage creates a new message
@@ -36,6 +44,24 @@ func NewMessage(msgType, sender, receipient, payload string) *Message {
 	}
 }
 
+// Hash generates the hash of the message
+func (msg *Message) Hash() string {
+	return utils.Hash(msg.GenerateDataForSigning())
+}
+
+// GenerateDataForSigning generates the data that needs to be signed
+func (msg *Message) GenerateDataForSigning() string {
+	return fmt.Sprintf("%s|%s|%s|%s|%d|%s", msg.Type, msg.Sender, msg.Receipient, msg.Payload, msg.Timestamp, msg.PublicKey)
+}
+
+// VerifySignature checks if the message is signed by its identity key
+func (msg *Message) VerifySignature() error {
+	if msg.PublicKey == "" || msg.Signature == "" {
+		return fmt.Errorf("message is not signed")
+	}
+	return utils.VerifySignature(msg.PublicKey, msg.GenerateDataForSigning(), msg.Signature)
+}
+
 // Serialize converts the Message to a JSON string
 func (msg *Message) Serialize() (string, error) {
 	data, err := json.Marshal(msg)
@@ -0,0 +1,107 @@
+package mining
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/utils"
+)
+
+// IsEnabled checks if the mining loop mines blocks
+func (miner *Miner) IsEnabled() bool {
+	miner.mutex.Lock()
+	defer miner.mutex.Unlock()
+
+	return miner.enabled
+}
+
+// SetEnabled enables or disables the mining loop. Disabling it interrupts
+// the proof of work in progress.
+func (miner *Miner) SetEnabled(enabled bool) {
+	miner.mutex.Lock()
+	wasEnabled := miner.enabled
+	miner.enabled = enabled
+	miner.mutex.Unlock()
+
+	if wasEnabled == enabled {
+		return
+	}
+	if enabled {
+		log.Println("Mining enabled")
+	} else {
+		log.Println("Mining disabled")
+		miner.StopPoW()
+	}
+	miner.notify()
+}
+
+// GetThreads returns the number of proof of work workers
+func (miner *Miner) GetThreads() int {
+	miner.mutex.Lock()
+	defer miner.mutex.Unlock()
+
+	return miner.threads
+}
+
+// SetThreads sets the number of proof of work workers, and restarts the
+// proof of work in progress with them
+func (miner *Miner) SetThreads(threads int) error {
+	if threads < 1 {
+		return fmt.Errorf("invalid number of threads: %d", threads)
+	}
+
+	miner.mutex.Lock()
+	changed := miner.threads != threads
+	miner.threads = threads
+	miner.mutex.Unlock()
+
+	if changed {
+		log.Printf("Mining with %d threads\n", threads)
+		miner.restart()
+	}
+	return nil
+}
+
+// GetAddress returns the wallet address receiving the block rewards
+func (miner *Miner) GetAddress() string {
+	miner.mutex.Lock()
+	defer miner.mutex.Unlock()
+
+	return miner.address
+}
+
+// SetAddress sets the wallet address receiving the block rewards, and
+// restarts the proof of work in progress on a block paying it
+func (miner *Miner) SetAddress(address string) error {
+	if err := utils.ValidateAddress(address); err != nil {
+		return err
+	}
+
+	miner.mutex.Lock()
+	changed := miner.address != address
+	miner.address = address
+	miner.mutex.Unlock()
+
+	if changed {
+		log.Printf("Mining to address %s\n", address)
+		miner.restart()
+	}
+	return nil
+}
+
+// restart interrupts the proof of work in progress, so that the mining loop
+// starts over with the current settings
+func (miner *Miner) restart() {
+	if miner.IsEnabled() {
+		miner.StopPoW()
+		miner.notify()
+	}
+}
+
+// notify wakes the mining loop up
+func (miner *Miner) notify() {
+	select {
+	case miner.wake <- struct{}{}:
+	default: // A wake up is already pending
+	}
+}
@@ -4,35 +4,255 @@ import (
 	"fmt"
 	"sync"
 
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/amount"
 	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/block"
 	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/transaction"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/utxo"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/utils"
+)
+
+const (
+	MAXMEMPOOLSIZE  = 5_000_000         // Maximum total size of the pending transactions in bytes
+	MEMPOOLEXPIRY   = 72 * 60 * 60      // Seconds a transaction may stay pending
+	MINRELAYFEERATE = amount.Amount(10) // Minimum fee rate of an empty pool, in base units per byte
+	RELAYFEEGROWTH  = 9                 // The minimum fee rate of a full pool is (1 + RELAYFEEGROWTH) times the one of an empty pool
+	MAXREPLACEMENTS = 100               // Maximum number of pending transactions a replacement may evict
 )
 
 type Mempool struct {
-	Transactions map[string]*transaction.Transaction // TransactionID -> Transaction
-	Mutex        *sync.RWMutex                       // Mutex for the mempool
+	Entries         map[string]*TxEntry        // TransactionID -> Entry of the pending transaction
+	Spends          map[utxo.OutPoint]string   // OutPoint -> ID of the pending transaction spending it
+	Parents         map[string]map[string]bool // TransactionID -> IDs of the pending transactions it spends from
+	Children        map[string]map[string]bool // TransactionID -> IDs of the pending transactions spending from it
+	byFeeRate       *txHeap                    // Entries by decreasing fee rate
+	byLowestFeeRate *txHeap                    // Entries by increasing fee rate
+	byAge           *txHeap                    // Entries by time added, the oldest first
+	Size            int                        // Total size of the pending transactions in bytes
+	MaxSize         int                        // Maximum total size of the pending transactions in bytes
+	Expiry          int64                      // Seconds a transaction may stay pending
+	MinRelayFeeRate amount.Amount              // Minimum fee rate of an empty pool, in base units per byte
+	Evicted         int64                      // Number of transactions evicted to make room
+	Expired         int64                      // Number of transactions removed after staying pending too long
+	Replaced        int64                      // Number of transactions replaced by a transaction paying a higher fee
+	Sequence        uint64                     // Incremented whenever a transaction enters or leaves the pool
+	Mutex           *sync.RWMutex              // Mutex for the mempool
+}
+
+type MempoolInfo struct {
+	Transactions int           `json:"transactions"` // Number of pending transactions
+	Size         int           `json:"size"`         // Total size of the pending transactions in bytes
+	MaxSize      int           `json:"max_size"`     // Maximum total size in bytes
+	MinFeeRate   amount.Amount `json:"min_fee_rate"` // Fee rate a new transaction must pay, in base units per byte
+	Evicted      int64         `json:"evicted"`      // Number of transactions evicted to make room
+	Expired      int64         `json:"expired"`      // Number of transactions expired
+	Replaced     int64         `json:"replaced"`     // Number of transactions replaced by fee
 }
 
 // NewMempool creates a new mempool
 func NewMempool() *Mempool {
 	return &Mempool{
-		Transactions: make(map[string]*transaction.Transaction),
-		Mutex:        &sync.RWMutex{},
+		Entries:         make(map[string]*TxEntry),
+		Spends:          make(map[utxo.OutPoint]string),
+		Parents:         make(map[string]map[string]bool),
+		Children:        make(map[string]map[string]bool),
+		byFeeRate:       newTxHeap(hasHigherFeeRate),
+		byLowestFeeRate: newTxHeap(hasLowerFeeRate),
+		byAge:           newTxHeap(isOlder),
+		MaxSize:         MAXMEMPOOLSIZE,
+		Expiry:          MEMPOOLEXPIRY,
+		MinRelayFeeRate: MINRELAYFEERATE,
+		Mutex:           &sync.RWMutex{},
 	}
 }
 
-// AddTransaction adds a transaction to the pool
+// AddTransaction adds a transaction to the pool. The transaction must pay the
+// minimum fee rate, and if the pool is full, the transactions paying the
+// lowest fee rate are evicted to make room for it. A transaction spending an
+// output already spent by pending transactions replaces them if it pays
+// enough to cover them (see replacementsFor). If the transaction is rejected,
+// the pool is left as it was.
 func (mp *Mempool) AddTransaction(tx *transaction.Transaction) error {
 	mp.Mutex.Lock()
 	defer mp.Mutex.Unlock()
 
-	if mp.Transactions[tx.TransactionID] != nil {
+	if mp.Entries[tx.TransactionID] != nil {
 		return fmt.Errorf("transaction with ID %s already exists", tx.TransactionID)
 	}
-	mp.Transactions[tx.TransactionID] = tx
+
+	// Remove the transactions pending for too long
+	now := utils.GetCurrentTimeInUnix()
+	mp.expire(now)
+
+	// Validate the fee rate against the fill level of the pool
+	entry := NewTxEntry(tx, now)
+	if minFeeRate := mp.minFeeRate(); entry.FeeRate < minFeeRate {
+		return fmt.Errorf("fee rate %d is below the minimum fee rate %d", entry.FeeRate, minFeeRate)
+	}
+
+	// Find the pending transactions the transaction replaces
+	replaced, err := mp.replacementsFor(entry)
+	if err != nil {
+		return err
+	}
+	replacedSize := 0
+	for _, txID := range replaced {
+		replacedSize += mp.Entries[txID].Size
+	}
+
+	if lowest := mp.byLowestFeeRate.peek(); lowest != nil && mp.Size-replacedSize+entry.Size > mp.MaxSize && entry.FeeRate <= lowest.FeeRate {
+		return fmt.Errorf("mempool is full: fee rate %d does not exceed the lowest fee rate %d", entry.FeeRate, lowest.FeeRate)
+	}
+
+	// Evict the replaced transactions and add the transaction
+	removed := make([]*TxEntry, 0, len(replaced))
+	for _, txID := range replaced {
+		removed = append(removed, mp.Entries[txID])
+		mp.RemoveTransaction(txID)
+	}
+	mp.insert(entry)
+
+	// Evict the transactions paying the lowest fee rate, with their
+	// descendants, until the pool fits
+	nReplaced := len(removed)
+	for mp.Size > mp.MaxSize {
+		lowestID := mp.byLowestFeeRate.peek().Tx.TransactionID
+		evicted := append(mp.getDescendants(lowestID), lowestID)
+
+		// Roll the pool back if the transaction itself would be evicted
+		for _, txID := range evicted {
+			if txID == tx.TransactionID {
+				mp.RemoveTransaction(tx.TransactionID)
+				for _, e := range removed {
+					mp.insert(e)
+				}
+				return fmt.Errorf("mempool is full: transaction %s would be evicted", tx.TransactionID)
+			}
+		}
+		for _, txID := range evicted {
+			removed = append(removed, mp.Entries[txID])
+			mp.RemoveTransaction(txID)
+		}
+	}
+	mp.Replaced += int64(nReplaced)
+	mp.Evicted += int64(len(removed) - nReplaced)
 	return nil
 }
 
+// insert adds an entry to the pool and links it to the pending transactions
+// it spends from and that spend from it
+func (mp *Mempool) insert(entry *TxEntry) {
+	tx := entry.Tx
+	mp.Entries[tx.TransactionID] = entry
+	mp.byFeeRate.add(entry)
+	mp.byLowestFeeRate.add(entry)
+	mp.byAge.add(entry)
+	mp.Size += entry.Size
+	mp.Sequence++
+	mp.Parents[tx.TransactionID] = make(map[string]bool)
+	mp.Children[tx.TransactionID] = make(map[string]bool)
+	for _, input := range tx.Inputs {
+		mp.Spends[utxo.NewOutPointFromInput(input)] = tx.TransactionID
+
+		// Link the transaction to the pending transaction it spends from
+		if mp.Entries[input.TxID] != nil {
+			mp.link(input.TxID, tx.TransactionID)
+		}
+	}
+
+	// Link the transaction to the pending transactions already spending from it
+	for i := range tx.Outputs {
+		if spender, ok := mp.Spends[utxo.NewOutPoint(tx.TransactionID, i)]; ok {
+			mp.link(tx.TransactionID, spender)
+		}
+	}
+}
+
+// replacementsFor returns the IDs of the pending transactions a new
+// transaction replaces: the ones spending the same outputs, with their
+// descendants. The new transaction must pay a fee rate higher than each
+// conflicting transaction by at least MinRelayFeeRate, and a fee covering the
+// fees of all the replaced transactions plus its own size at MinRelayFeeRate.
+func (mp *Mempool) replacementsFor(entry *TxEntry) ([]string, error) {
+	// Collect the conflicting transactions
+	conflicts := make(map[string]bool)
+	for _, input := range entry.Tx.Inputs {
+		if spender, ok := mp.Spends[utxo.NewOutPointFromInput(input)]; ok {
+			conflicts[spender] = true
+		}
+	}
+	if len(conflicts) == 0 {
+		return nil, nil
+	}
+
+	// Collect the conflicting transactions with their descendants
+	replaced := make([]string, 0)
+	isReplaced := make(map[string]bool)
+	for conflictID := range conflicts {
+		for _, txID := range append(mp.getDescendants(conflictID), conflictID) {
+			if !isReplaced[txID] {
+				isReplaced[txID] = true
+				replaced = append(replaced, txID)
+			}
+		}
+	}
+	if len(replaced) > MAXREPLACEMENTS {
+		return nil, fmt.Errorf("transaction %s would replace %d transactions, more than %d", entry.Tx.TransactionID, len(replaced), MAXREPLACEMENTS)
+	}
+
+	// A replacement cannot spend the outputs of the transactions it replaces
+	for _, input := range entry.Tx.Inputs {
+		if isReplaced[input.TxID] {
+			return nil, fmt.Errorf("transaction %s spends an output of the replaced transaction %s", entry.Tx.TransactionID, input.TxID)
+		}
+	}
+
+	// Validate the fee rate against each conflicting transaction
+	for conflictID := range conflicts {
+		conflict := mp.Entries[conflictID]
+		if entry.FeeRate < conflict.FeeRate+mp.MinRelayFeeRate {
+			return nil, fmt.Errorf("input already spent by transaction %s: replacement fee rate %d must be at least %d", conflictID, entry.FeeRate, conflict.FeeRate+mp.MinRelayFeeRate)
+		}
+	}
+
+	// Validate the fee against the fees of all the replaced transactions
+	replacedFees := amount.Amount(0)
+	for _, txID := range replaced {
+		var err error
+		if replacedFees, err = replacedFees.Add(mp.Entries[txID].Tx.Fee); err != nil {
+			return nil, err
+		}
+	}
+	minFee, err := replacedFees.Add(mp.MinRelayFeeRate * amount.Amount(entry.Size))
+	if err != nil {
+		return nil, err
+	}
+	if entry.Tx.Fee < minFee {
+		return nil, fmt.Errorf("replacement fee %s must be at least %s", entry.Tx.Fee, minFee)
+	}
+
+	return replaced, nil
+}
+
+// expire removes the transactions added before the expiry, with their descendants
+func (mp *Mempool) expire(now int64) {
+	for oldest := mp.byAge.peek(); oldest != nil && now-oldest.AddedAt > mp.Expiry; oldest = mp.byAge.peek() {
+		mp.Expired += int64(len(mp.RemoveTransactionWithDescendants(oldest.Tx.TransactionID)))
+	}
+}
+
+// minFeeRate returns the fee rate a new transaction must pay, which rises
+// linearly from MinRelayFeeRate as the pool fills
+func (mp *Mempool) minFeeRate() amount.Amount {
+	growth := amount.Amount(int64(mp.MinRelayFeeRate) * RELAYFEEGROWTH * int64(mp.Size) / int64(mp.MaxSize))
+	return mp.MinRelayFeeRate + growth
+}
+
+// link records that the child transaction spends an output of the parent
+func (mp *Mempool) link(parentID, childID string) {
+	mp.Parents[childID][parentID] = true
+	mp.Children[parentID][childID] = true
+}
+
 // RemoveTransactionsInBlock removes transactions in a block from the pool
 func (mp *Mempool) RemoveTransactionsInBlock(block *block.Block) {
 	mp.Mutex.Lock()
@@ -40,6 +260,13 @@ func (mp *Mempool) RemoveTransactionsInBlock(block *block.Block) {
 
 	for _, tx := range block.Transactions {
 		mp.RemoveTransaction(tx.TransactionID)
+
+		// Remove the pending transactions double spending the confirmed one
+		for _, input := range tx.Inputs {
+			if spender, ok := mp.Spends[utxo.NewOutPointFromInput(input)]; ok {
+				mp.RemoveTransactionWithDescendants(spender)
+			}
+		}
 	}
 }
 
@@ -54,30 +281,225 @@ func (mp *Mempool) RemoveTransactions(transactionIds []string) {
 
 // RemoveTransaction removes a transaction from the pool
 func (mp *Mempool) RemoveTransaction(txID string) error {
-	if mp.Transactions[txID] == nil {
+	entry := mp.Entries[txID]
+	if entry == nil {
 		return fmt.Errorf("transaction with ID %s does not exist", txID)
 	}
-	delete(mp.Transactions, txID)
+
+	tx := entry.Tx
+
+	for _, input := range tx.Inputs {
+		delete(mp.Spends, utxo.NewOutPointFromInput(input))
+	}
+
+	// Unlink the transaction from its parents and children
+	for parentID := range mp.Parents[txID] {
+		delete(mp.Children[parentID], txID)
+	}
+	for childID := range mp.Children[txID] {
+		delete(mp.Parents[childID], txID)
+	}
+	delete(mp.Parents, txID)
+	delete(mp.Children, txID)
+
+	mp.byFeeRate.remove(txID)
+	mp.byLowestFeeRate.remove(txID)
+	mp.byAge.remove(txID)
+	mp.Size -= entry.Size
+	mp.Sequence++
+	delete(mp.Entries, txID)
+	return nil
+}
+
+// RemoveTransactionWithDescendants removes a transaction and all the pending
+// transactions spending from it, and returns the IDs of the removed transactions
+func (mp *Mempool) RemoveTransactionWithDescendants(txID string) []string {
+	if mp.Entries[txID] == nil {
+		return nil
+	}
+
+	removed := append(mp.getDescendants(txID), txID)
+	for _, id := range removed {
+		mp.RemoveTransaction(id)
+	}
+	return removed
+}
+
+// RemoveAllTransactions empties the pool and returns its transactions,
+// parents before children
+func (mp *Mempool) RemoveAllTransactions() []*transaction.Transaction {
+	mp.Mutex.Lock()
+	defer mp.Mutex.Unlock()
+
+	txSlice := mp.sortTopologically()
+	mp.Entries = make(map[string]*TxEntry)
+	mp.Spends = make(map[utxo.OutPoint]string)
+	mp.Parents = make(map[string]map[string]bool)
+	mp.Children = make(map[string]map[string]bool)
+	mp.byFeeRate = newTxHeap(hasHigherFeeRate)
+	mp.byLowestFeeRate = newTxHeap(hasLowerFeeRate)
+	mp.byAge = newTxHeap(isOlder)
+	mp.Size = 0
+	return txSlice
+}
+
+// GetTransaction returns the pending transaction with the given ID, or nil
+func (mp *Mempool) GetTransaction(txID string) *transaction.Transaction {
+	mp.Mutex.RLock()
+	defer mp.Mutex.RUnlock()
+
+	if entry := mp.Entries[txID]; entry != nil {
+		return entry.Tx
+	}
 	return nil
 }
 
-// GetTopNRewardingTransactions returns the top N rewarding transactions
+// GetOutput returns an output of a pending transaction
+func (mp *Mempool) GetOutput(op utxo.OutPoint) (*transaction.TxOutput, bool) {
+	mp.Mutex.RLock()
+	defer mp.Mutex.RUnlock()
+
+	entry := mp.Entries[op.TxID]
+	if entry == nil || op.Index < 0 || op.Index >= len(entry.Tx.Outputs) {
+		return nil, false
+	}
+	return entry.Tx.Outputs[op.Index], true
+}
+
+// GetParents returns the IDs of the pending transactions a transaction spends from
+func (mp *Mempool) GetParents(txID string) []string {
+	mp.Mutex.RLock()
+	defer mp.Mutex.RUnlock()
+
+	parentIDs := make([]string, 0, len(mp.Parents[txID]))
+	for parentID := range mp.Parents[txID] {
+		parentIDs = append(parentIDs, parentID)
+	}
+	return parentIDs
+}
+
+// GetAncestors returns the IDs of the pending transactions a transaction
+// depends on, directly or through other pending transactions
+func (mp *Mempool) GetAncestors(txID string) []string {
+	mp.Mutex.RLock()
+	defer mp.Mutex.RUnlock()
+
+	return collectLinked(mp.Parents, txID)
+}
+
+// GetEntriesWithAncestors returns the entries of the pending transactions and
+// the IDs of the pending ancestors of each, read at once
+func (mp *Mempool) GetEntriesWithAncestors() (map[string]*TxEntry, map[string][]string) {
+	mp.Mutex.RLock()
+	defer mp.Mutex.RUnlock()
+
+	entries := make(map[string]*TxEntry, len(mp.Entries))
+	ancestors := make(map[string][]string, len(mp.Entries))
+	for txID, entry := range mp.Entries {
+		entries[txID] = entry
+		ancestors[txID] = collectLinked(mp.Parents, txID)
+	}
+	return entries, ancestors
+}
+
+// GetDescendants returns the IDs of the pending transactions depending on a
+// transaction, directly or through other pending transactions
+func (mp *Mempool) GetDescendants(txID string) []string {
+	mp.Mutex.RLock()
+	defer mp.Mutex.RUnlock()
+
+	return mp.getDescendants(txID)
+}
+
+func (mp *Mempool) getDescendants(txID string) []string {
+	return collectLinked(mp.Children, txID)
+}
+
+// collectLinked walks the links from a transaction and returns the IDs of
+// every transaction reached, excluding the transaction itself
+func collectLinked(links map[string]map[string]bool, txID string) []string {
+	visited := map[string]bool{txID: true}
+	collected := make([]string, 0)
+	for queue := []string{txID}; len(queue) > 0; queue = queue[1:] {
+		for linkedID := range links[queue[0]] {
+			if !visited[linkedID] {
+				visited[linkedID] = true
+				collected = append(collected, linkedID)
+				queue = append(queue, linkedID)
+			}
+		}
+	}
+	return collected
+}
+
+// sortTopologically returns the pending transactions, parents before children
+func (mp *Mempool) sortTopologically() []*transaction.Transaction {
+	txSlice := make([]*transaction.Transaction, 0, len(mp.Entries))
+	nParents := make(map[string]int, len(mp.Entries))
+	for txID, entry := range mp.Entries {
+		nParents[txID] = len(mp.Parents[txID])
+		if nParents[txID] == 0 {
+			txSlice = append(txSlice, entry.Tx)
+		}
+	}
+
+	// Append each child once all its parents are in the slice
+	for i := 0; i < len(txSlice); i++ {
+		for childID := range mp.Children[txSlice[i].TransactionID] {
+			nParents[childID]--
+			if nParents[childID] == 0 {
+				txSlice = append(txSlice, mp.Entries[childID].Tx)
+			}
+		}
+	}
+	return txSlice
+}
+
+// GetTransactions returns all pending transactions
+func (mp *Mempool) GetTransactions() []*transaction.Transaction {
+	mp.Mutex.RLock()
+	defer mp.Mutex.RUnlock()
+
+	txSlice := make([]*transaction.Transaction, 0, len(mp.Entries))
+	for _, entry := range mp.Entries {
+		txSlice = append(txSlice, entry.Tx)
+	}
+	return txSlice
+}
+
+// GetTopNRewardingTransactions returns the N transactions paying the highest fee rate
 func (mp *Mempool) GetTopNRewardingTransactions(n int) []*transaction.Transaction {
 	mp.Mutex.RLock()
 	defer mp.Mutex.RUnlock()
 
-	// Convert the map to a slice
-	txSlice := make([]*transaction.Transaction, 0, len(mp.Transactions))
-	for _, tx := range mp.Transactions {
-		txSlice = append(txSlice, tx)
+	entries := mp.byFeeRate.top(n)
+	txSlice := make([]*transaction.Transaction, len(entries))
+	for i, entry := range entries {
+		txSlice[i] = entry.Tx
 	}
+	return txSlice
+}
 
-	// Sort the transactions by fee
-	transaction.SortTransactionsByFee(txSlice)
+// GetSequence returns a number that changes whenever the pending transactions change
+func (mp *Mempool) GetSequence() uint64 {
+	mp.Mutex.RLock()
+	defer mp.Mutex.RUnlock()
+
+	return mp.Sequence
+}
+
+// GetInfo returns the size, the limits and the eviction counters of the pool
+func (mp *Mempool) GetInfo() *MempoolInfo {
+	mp.Mutex.RLock()
+	defer mp.Mutex.RUnlock()
 
-	// Get the top N rewarding transactions
-	if n > len(txSlice) {
-		n = len(txSlice)
+	return &MempoolInfo{
+		Transactions: len(mp.Entries),
+		Size:         mp.Size,
+		MaxSize:      mp.MaxSize,
+		MinFeeRate:   mp.minFeeRate(),
+		Evicted:      mp.Evicted,
+		Expired:      mp.Expired,
+		Replaced:     mp.Replaced,
 	}
-	return txSlice[:n]
 }
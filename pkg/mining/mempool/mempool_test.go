@@ -0,0 +1,376 @@
+package mempool_test
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/amount"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/transaction"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/utxo"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/mining/mempool"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/utils"
+)
+
+var testAddress = strings.Repeat("ab", 64) // Sender and recipient of the test transactions
+
+// funding returns an input spending a confirmed output
+func funding(name string) *transaction.TxInput {
+	return transaction.NewTxInput(utils.Hash(name), 0)
+}
+
+// newTx creates a transaction spending the inputs into the given number of
+// outputs, paying exactly the given fee rate
+func newTx(rate amount.Amount, nOutputs int, inputs ...*transaction.TxInput) *transaction.Transaction {
+	tx := &transaction.Transaction{
+		Sender:        testAddress,
+		Inputs:        inputs,
+		TransactionID: strings.Repeat("0", 64),
+		Timestamp:     1700000000,
+	}
+	for i := 0; i < nOutputs; i++ {
+		tx.Outputs = append(tx.Outputs, transaction.NewTxOutput(1000, testAddress))
+	}
+
+	// The digits of the fee count in the size it pays for
+	for tx.Fee != rate*amount.Amount(tx.Size()) {
+		tx.Fee = rate * amount.Amount(tx.Size())
+	}
+	tx.TransactionID = tx.GenerateTransactionID()
+	return tx
+}
+
+// spend returns an input spending an output of a transaction
+func spend(tx *transaction.Transaction, index int) *transaction.TxInput {
+	return transaction.NewTxInput(tx.TransactionID, index)
+}
+
+// addAll adds the transactions to the pool, failing the test on an error
+func addAll(t *testing.T, mp *mempool.Mempool, txs ...*transaction.Transaction) {
+	t.Helper()
+
+	for _, tx := range txs {
+		if err := mp.AddTransaction(tx); err != nil {
+			t.Fatalf("failed to add transaction %s: %v", tx.TransactionID, err)
+		}
+	}
+}
+
+// assertPending checks that exactly the given transactions are pending
+func assertPending(t *testing.T, mp *mempool.Mempool, txs ...*transaction.Transaction) {
+	t.Helper()
+
+	size := 0
+	for _, tx := range txs {
+		if mp.GetTransaction(tx.TransactionID) == nil {
+			t.Errorf("transaction %s is not pending", tx.TransactionID)
+		}
+		for _, input := range tx.Inputs {
+			if spender := mp.Spends[utxo.NewOutPointFromInput(input)]; spender != tx.TransactionID {
+				t.Errorf("input %s:%d is spent by %s, want %s", input.TxID, input.OutputIndex, spender, tx.TransactionID)
+			}
+		}
+		size += tx.Size()
+	}
+	if n := len(mp.GetTransactions()); n != len(txs) {
+		t.Errorf("pending transactions = %d, want %d", n, len(txs))
+	}
+	if mp.Size != size {
+		t.Errorf("size = %d, want %d", mp.Size, size)
+	}
+}
+
+func TestReplaceByFee(t *testing.T) {
+	const rate = amount.Amount(100)
+
+	tests := []struct {
+		name      string
+		rate      amount.Amount // Fee rate of the replacement
+		nOutputs  int           // Outputs of the replacement; the conflict has 2
+		withChild bool          // Whether a pending child spends from the conflict
+		wantErr   bool
+	}{
+		{name: "fee rate bumped by the minimum relay fee rate", rate: rate + mempool.MINRELAYFEERATE, nOutputs: 2, wantErr: false},
+		{name: "fee rate bumped by less", rate: rate + mempool.MINRELAYFEERATE - 1, nOutputs: 2, wantErr: true},
+		{name: "same fee rate", rate: rate, nOutputs: 2, wantErr: true},
+		{name: "higher fee rate but lower fee", rate: rate + mempool.MINRELAYFEERATE, nOutputs: 1, wantErr: true},
+		{name: "much higher fee rate and lower fee", rate: 2 * rate, nOutputs: 1, wantErr: false},
+		{name: "fee not covering the child", rate: rate + mempool.MINRELAYFEERATE, nOutputs: 2, withChild: true, wantErr: true},
+		{name: "fee covering the child", rate: 3 * rate, nOutputs: 2, withChild: true, wantErr: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mp := mempool.NewMempool()
+			pending := []*transaction.Transaction{newTx(rate, 2, funding("a"))}
+			if tt.withChild {
+				pending = append(pending, newTx(rate, 1, spend(pending[0], 0)))
+			}
+			addAll(t, mp, pending...)
+
+			// The replacement evicts the conflict with its child
+			replacement := newTx(tt.rate, tt.nOutputs, funding("a"))
+			err := mp.AddTransaction(replacement)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("AddTransaction() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				assertPending(t, mp, pending...)
+				if mp.Replaced != 0 {
+					t.Errorf("replaced = %d, want 0", mp.Replaced)
+				}
+				return
+			}
+			assertPending(t, mp, replacement)
+			if mp.Replaced != int64(len(pending)) {
+				t.Errorf("replaced = %d, want %d", mp.Replaced, len(pending))
+			}
+		})
+	}
+}
+
+func TestReplaceByFeeLimit(t *testing.T) {
+	tests := []struct {
+		name         string
+		nDescendants int // Pending descendants of the conflict
+		wantErr      bool
+	}{
+		{name: "replacing the limit", nDescendants: mempool.MAXREPLACEMENTS - 1, wantErr: false},
+		{name: "replacing more than the limit", nDescendants: mempool.MAXREPLACEMENTS, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mp := mempool.NewMempool()
+
+			// A chain of transactions from the conflict
+			chain := []*transaction.Transaction{newTx(100, 1, funding("a"))}
+			for i := 0; i < tt.nDescendants; i++ {
+				chain = append(chain, newTx(100, 1, spend(chain[i], 0)))
+			}
+			addAll(t, mp, chain...)
+
+			// The replacement pays for the whole chain
+			replacement := newTx(100*(mempool.MAXREPLACEMENTS+2), 1, funding("a"))
+			err := mp.AddTransaction(replacement)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("AddTransaction() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				assertPending(t, mp, chain...)
+			} else {
+				assertPending(t, mp, replacement)
+			}
+		})
+	}
+}
+
+func TestReplacementSpendingReplacedOutput(t *testing.T) {
+	mp := mempool.NewMempool()
+	conflict := newTx(100, 2, funding("a"))
+	addAll(t, mp, conflict)
+
+	// The replacement spends the same output and an output of the conflict
+	replacement := newTx(1000, 1, funding("a"), spend(conflict, 1))
+	if err := mp.AddTransaction(replacement); err == nil {
+		t.Fatalf("replacement spending an output of the replaced transaction was accepted")
+	}
+	assertPending(t, mp, conflict)
+}
+
+func TestReplacementEvictedRollsBack(t *testing.T) {
+	mp := mempool.NewMempool()
+
+	// A parent paying a low fee rate, and a conflict filling the pool
+	parent := newTx(20, 1, funding("a"))
+	conflict := newTx(100, 1, funding("b"))
+	mp.MaxSize = parent.Size() + conflict.Size() + 10
+	addAll(t, mp, parent, conflict)
+
+	// The replacement of the conflict is larger and spends from the parent,
+	// so making room evicts the parent and the replacement with it
+	replacement := newTx(300, 1, funding("b"), spend(parent, 0))
+	if err := mp.AddTransaction(replacement); err == nil {
+		t.Fatalf("replacement evicted with its parent was accepted")
+	}
+
+	// The pool is left as it was
+	assertPending(t, mp, parent, conflict)
+	if mp.Replaced != 0 || mp.Evicted != 0 {
+		t.Errorf("replaced = %d, evicted = %d, want 0", mp.Replaced, mp.Evicted)
+	}
+	if children := mp.GetDescendants(parent.TransactionID); len(children) != 0 {
+		t.Errorf("descendants of the parent = %v, want none", children)
+	}
+
+	// The conflict can still be replaced
+	replacement = newTx(300, 1, funding("b"))
+	addAll(t, mp, replacement)
+	assertPending(t, mp, parent, replacement)
+	if mp.Replaced != 1 {
+		t.Errorf("replaced = %d, want 1", mp.Replaced)
+	}
+}
+
+// sizeOf returns the total size of the transactions
+func sizeOf(txs ...*transaction.Transaction) int {
+	size := 0
+	for _, tx := range txs {
+		size += tx.Size()
+	}
+	return size
+}
+
+func TestEvictionAtMaxSize(t *testing.T) {
+	mp := mempool.NewMempool()
+
+	// The lowest fee rate has a child paying more than another transaction
+	lowest := newTx(200, 1, funding("a"))
+	child := newTx(300, 1, spend(lowest, 0))
+	other := newTx(400, 1, funding("b"))
+	mp.MaxSize = sizeOf(lowest, child, other) + 10
+	addAll(t, mp, lowest, child, other)
+
+	// Making room evicts the lowest fee rate with its child
+	high := newTx(1000, 1, funding("c"))
+	addAll(t, mp, high)
+	assertPending(t, mp, other, high)
+	if mp.Evicted != 2 {
+		t.Errorf("evicted = %d, want 2", mp.Evicted)
+	}
+	if mp.Size > mp.MaxSize {
+		t.Errorf("size = %d exceeds the maximum size %d", mp.Size, mp.MaxSize)
+	}
+}
+
+func TestRejectionAtLowestFeeRate(t *testing.T) {
+	const lowestRate = amount.Amount(200)
+
+	tests := []struct {
+		name    string
+		rate    amount.Amount // Fee rate of the transaction added to the full pool
+		wantErr bool
+	}{
+		{name: "below the lowest fee rate", rate: lowestRate - 1, wantErr: true},
+		{name: "at the lowest fee rate", rate: lowestRate, wantErr: true},
+		{name: "above the lowest fee rate", rate: lowestRate + 1, wantErr: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mp := mempool.NewMempool()
+			lowest := newTx(lowestRate, 1, funding("a"))
+			other := newTx(300, 1, funding("b"))
+			mp.MaxSize = sizeOf(lowest, other)
+			addAll(t, mp, lowest, other)
+
+			tx := newTx(tt.rate, 1, funding("c"))
+			err := mp.AddTransaction(tx)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("AddTransaction() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				assertPending(t, mp, lowest, other)
+				if mp.Evicted != 0 {
+					t.Errorf("evicted = %d, want 0", mp.Evicted)
+				}
+				return
+			}
+			assertPending(t, mp, other, tx)
+			if mp.Evicted != 1 {
+				t.Errorf("evicted = %d, want 1", mp.Evicted)
+			}
+		})
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	mp := mempool.NewMempool()
+	old := newTx(100, 1, funding("a"))
+	child := newTx(100, 1, spend(old, 0))
+	addAll(t, mp, old, child)
+
+	// Age the pending transactions past the expiry
+	for _, entry := range mp.Entries {
+		entry.AddedAt -= mp.Expiry + 1
+	}
+
+	// Adding a transaction removes them
+	recent := newTx(100, 1, funding("b"))
+	addAll(t, mp, recent)
+	assertPending(t, mp, recent)
+	if mp.Expired != 2 {
+		t.Errorf("expired = %d, want 2", mp.Expired)
+	}
+
+	// A transaction pending for less than the expiry stays
+	addAll(t, mp, newTx(100, 1, funding("c")))
+	if mp.GetTransaction(recent.TransactionID) == nil || mp.Expired != 2 {
+		t.Errorf("recent transaction expired")
+	}
+}
+
+func TestMinFeeRateGrowth(t *testing.T) {
+	mp := mempool.NewMempool()
+	txs := []*transaction.Transaction{
+		newTx(1000, 1, funding("a")),
+		newTx(1000, 1, funding("b")),
+		newTx(1000, 1, funding("c")),
+	}
+	mp.MaxSize = sizeOf(txs...)
+
+	// The minimum fee rate rises from the minimum relay fee rate as the pool fills
+	if got := mp.GetInfo().MinFeeRate; got != mempool.MINRELAYFEERATE {
+		t.Errorf("minimum fee rate of the empty pool = %d, want %d", got, mempool.MINRELAYFEERATE)
+	}
+	previous := mempool.MINRELAYFEERATE
+	for _, tx := range txs {
+		addAll(t, mp, tx)
+		got := mp.GetInfo().MinFeeRate
+		if got <= previous {
+			t.Errorf("minimum fee rate at size %d = %d, want more than %d", mp.Size, got, previous)
+		}
+		previous = got
+	}
+	if want := mempool.MINRELAYFEERATE * (1 + mempool.RELAYFEEGROWTH); previous != want {
+		t.Errorf("minimum fee rate of the full pool = %d, want %d", previous, want)
+	}
+
+	// A transaction below the minimum fee rate is rejected, whatever it would evict
+	mp.MaxSize *= 2
+	minFeeRate := mp.GetInfo().MinFeeRate
+	if err := mp.AddTransaction(newTx(minFeeRate-1, 1, funding("d"))); err == nil {
+		t.Errorf("transaction below the minimum fee rate %d was accepted", minFeeRate)
+	}
+	addAll(t, mp, newTx(minFeeRate, 1, funding("d")))
+}
+
+func TestFeeRateOrdering(t *testing.T) {
+	mp := mempool.NewMempool()
+	rates := []amount.Amount{300, 100, 500, 200, 400, 100, 600}
+	for i, rate := range rates {
+		addAll(t, mp, newTx(rate, 1, funding(string(rune('a'+i)))))
+	}
+
+	// assertOrdered checks the first n transactions pay the given fee rates
+	assertOrdered := func(n int, want []amount.Amount) {
+		t.Helper()
+
+		top := mp.GetTopNRewardingTransactions(n)
+		got := make([]amount.Amount, len(top))
+		for i, tx := range top {
+			got[i] = tx.FeeRate()
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("fee rates of GetTopNRewardingTransactions(%d) = %v, want %v", n, got, want)
+		}
+	}
+	assertOrdered(3, []amount.Amount{600, 500, 400})
+	assertOrdered(len(rates), []amount.Amount{600, 500, 400, 300, 200, 100, 100})
+	assertOrdered(len(rates)+5, []amount.Amount{600, 500, 400, 300, 200, 100, 100})
+
+	// The order holds once transactions are removed
+	top := mp.GetTopNRewardingTransactions(3)
+	mp.RemoveTransactions([]string{top[0].TransactionID, top[2].TransactionID})
+	assertOrdered(len(rates), []amount.Amount{500, 300, 200, 100, 100})
+}
@@ -0,0 +1,135 @@
+package mempool
+
+import (
+	"container/heap"
+
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/amount"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/transaction"
+)
+
+type TxEntry struct {
+	Tx      *transaction.Transaction // The pending transaction
+	Size    int                      // Size of the serialized transaction in bytes
+	FeeRate amount.Amount            // Fee paid per byte
+	AddedAt int64                    // Unix time the transaction entered the pool
+}
+
+// NewTxEntry creates the entry of a transaction entering the pool at the given time
+func NewTxEntry(tx *transaction.Transaction, addedAt int64) *TxEntry {
+	return &TxEntry{
+		Tx:      tx,
+		Size:    tx.Size(),
+		FeeRate: tx.FeeRate(),
+		AddedAt: addedAt,
+	}
+}
+
+// hasHigherFeeRate orders the entries by decreasing fee rate, the oldest first on ties
+func hasHigherFeeRate(a, b *TxEntry) bool {
+	if a.FeeRate != b.FeeRate {
+		return a.FeeRate > b.FeeRate
+	}
+	if a.AddedAt != b.AddedAt {
+		return a.AddedAt < b.AddedAt
+	}
+	return a.Tx.TransactionID < b.Tx.TransactionID
+}
+
+// hasLowerFeeRate orders the entries by increasing fee rate, the newest first on ties
+func hasLowerFeeRate(a, b *TxEntry) bool {
+	return hasHigherFeeRate(b, a)
+}
+
+// isOlder orders the entries by the time they entered the pool, the oldest first
+func isOlder(a, b *TxEntry) bool {
+	if a.AddedAt != b.AddedAt {
+		return a.AddedAt < b.AddedAt
+	}
+	return a.Tx.TransactionID < b.Tx.TransactionID
+}
+
+// txHeap is a binary heap of entries that tracks the position of every entry,
+// so that any entry can be removed in O(log n). It implements heap.Interface.
+type txHeap struct {
+	entries  []*TxEntry               // Entries in heap order
+	position map[string]int           // TransactionID -> Index of the entry in entries
+	before   func(a, b *TxEntry) bool // Whether a comes before b
+}
+
+// newTxHeap creates an empty heap ordered by the given function
+func newTxHeap(before func(a, b *TxEntry) bool) *txHeap {
+	return &txHeap{
+		entries:  make([]*TxEntry, 0),
+		position: make(map[string]int),
+		before:   before,
+	}
+}
+
+func (h *txHeap) Len() int { return len(h.entries) }
+
+func (h *txHeap) Less(i, j int) bool { return h.before(h.entries[i], h.entries[j]) }
+
+func (h *txHeap) Swap(i, j int) {
+	h.entries[i], h.entries[j] = h.entries[j], h.entries[i]
+	h.position[h.entries[i].Tx.TransactionID] = i
+	h.position[h.entries[j].Tx.TransactionID] = j
+}
+
+func (h *txHeap) Push(x any) {
+	entry := x.(*TxEntry)
+	h.position[entry.Tx.TransactionID] = len(h.entries)
+	h.entries = append(h.entries, entry)
+}
+
+func (h *txHeap) Pop() any {
+	last := h.entries[len(h.entries)-1]
+	h.entries = h.entries[:len(h.entries)-1]
+	delete(h.position, last.Tx.TransactionID)
+	return last
+}
+
+// add adds an entry to the heap
+func (h *txHeap) add(entry *TxEntry) {
+	heap.Push(h, entry)
+}
+
+// remove removes the entry of a transaction from the heap
+func (h *txHeap) remove(txID string) {
+	if i, ok := h.position[txID]; ok {
+		heap.Remove(h, i)
+	}
+}
+
+// peek returns the first entry, or nil if the heap is empty
+func (h *txHeap) peek() *TxEntry {
+	if len(h.entries) == 0 {
+		return nil
+	}
+	return h.entries[0]
+}
+
+// top returns the first n entries in order without removing them, in
+// O(n log n) whatever the size of the heap
+func (h *txHeap) top(n int) []*TxEntry {
+	result := make([]*TxEntry, 0, min(n, len(h.entries)))
+	if len(h.entries) == 0 {
+		return result
+	}
+
+	// Walk the heap best first: the next entry is always the root of a
+	// subtree not visited yet
+	frontier := newTxHeap(h.before)
+	frontier.add(h.entries[0])
+	for len(result) < n && frontier.Len() > 0 {
+		entry := heap.Pop(frontier).(*TxEntry)
+		result = append(result, entry)
+
+		i := h.position[entry.Tx.TransactionID]
+		for _, child := range []int{2*i + 1, 2*i + 2} {
+			if child < len(h.entries) {
+				frontier.add(h.entries[child])
+			}
+		}
+	}
+	return result
+}
@@ -1,8 +1,13 @@
 package mining
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
-	"strings"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain"
@@ -11,14 +16,28 @@ import (
 	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/p2p/gossip"
 )
 
+const BLOCKRESERVEDSIZE = 1000 // Bytes of a block reserved for the header and the coinbase
+
+var ErrPoWInterrupted = errors.New("PoW was interrupted")
+
 type Miner struct {
 	NTransactions int                    // Number of transactions per block
-	Address       string                 // Wallet Address of the Miner
 	Blockchain    *blockchain.Blockchain // Blockchain reference
 	GossipManager *gossip.GossipManager  // Gossip manager reference
 	Mempool       *mempool.Mempool       // Mempool reference
-	StopMining    chan bool              // Channel to stop mining
-	StopRunning   chan bool              // Channel to stop the miner
+	address       string                 // Wallet address receiving the block rewards
+	threads       int                    // Number of proof of work workers
+	enabled       bool                   // Whether the mining loop mines blocks
+	wake          chan struct{}          // Channel waking the mining loop up when the settings change
+	generating    int                    // Number of Generate calls the mining loop gives way to
+	cancelPoW     context.CancelFunc     // Cancels the proof of work in progress
+	powParent     string                 // ID of the parent of the block of the proof of work in progress
+	ctx           context.Context        // Context of the miner, canceled once it is closed
+	cancel        context.CancelFunc     // Cancels the context of the miner
+	lastTemplate  *BlockTemplate         // Last block template assembled
+	hashrate      atomic.Uint64          // Hashes per second of the last proof of work search
+	mutex         *sync.Mutex            // Mutex protecting the settings, the proof of work in progress and the last block template
+	powMutex      *sync.Mutex            // Mutex letting a single proof of work run at a time
 }
 
 // NewMiner creates a new miner
@@ -28,82 +47,179 @@ func NewMiner(
 	gossipManager *gossip.GossipManager,
 	mempool *mempool.Mempool,
 ) *Miner {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Miner{
-		NTransactions: 10,
-		Address:       address,
+		NTransactions: blockchain.Params.BlockTransactions,
 		Blockchain:    blockchain,
 		GossipManager: gossipManager,
 		Mempool:       mempool,
-		StopMining:    make(chan bool, 1),
-		StopRunning:   make(chan bool, 1),
+		address:       address,
+		threads:       runtime.NumCPU(),
+		enabled:       !blockchain.Params.MineOnDemand,
+		wake:          make(chan struct{}, 1),
+		mutex:         &sync.Mutex{},
+		powMutex:      &sync.Mutex{},
+		ctx:           ctx,
+		cancel:        cancel,
 	}
 }
 
-// Run starts the mining loop
+// Run starts the mining loop, which mines blocks while mining is enabled.
+// On networks mining on demand, mining is disabled by default and blocks
+// are mined through Generate. The proof of work restarts on a new template
+// whenever the main chain moves past the block being mined.
 func (miner *Miner) Run() {
+	sub := miner.Blockchain.Events.Subscribe()
+	defer sub.Unsubscribe()
+	go miner.watchChain(sub)
+
 	for {
-		select {
-		case <-miner.StopRunning:
-			return
-		default:
-			// Get the top N rewarding transactions from the mempool
-			transactions := miner.Mempool.GetTopNRewardingTransactions(miner.NTransactions)
-			if len(transactions) == 0 {
-				log.Println("No transactions available. Pausing mining...")
-				time.Sleep(20 * time.Second) // Prevents high CPU usage when waiting for transactions
-				continue
+		// Wait while mining is disabled
+		if !miner.IsEnabled() {
+			if !miner.wait(-1) {
+				return
 			}
+			continue
+		}
+
+		if !miner.wait(miner.mineNextBlock()) {
+			return
+		}
+	}
+}
 
-			// Create a new block
-			newBlock := miner.Blockchain.NewBlock(transactions, miner.Address)
+// mineNextBlock assembles and mines the next block, and returns how long
+// the mining loop should pause before the next one
+func (miner *Miner) mineNextBlock() time.Duration {
+	params := miner.Blockchain.Params
 
-			// Perform Proof of Work
-			minedBlock := miner.PerformProofOfWork(newBlock)
-			if minedBlock == nil {
-				log.Println("PoW was interrupted.")
-				continue
-			}
+	miner.powMutex.Lock()
+	defer miner.powMutex.Unlock()
 
-			// Add Mined Block to Blockchain
-			err := miner.Blockchain.AddBlock(minedBlock)
-			if err != nil {
-				log.Println("Failed to add block to blockchain:", err)
-				continue
-			}
+	// Give way to Generate
+	ctx, cancel, ok := miner.newPoWContext(false)
+	if !ok {
+		return -1
+	}
+	defer cancel()
 
-			// Broadcast the Mined Block
-			miner.BroadcastBlock(minedBlock)
+	// Assemble a block from the packages paying the highest fee rate
+	template, err := miner.NewBlockTemplate()
+	if err != nil {
+		log.Println(err)
+		return params.MinerIdleInterval
+	}
+	if len(template.Block.Transactions) == 1 {
+		log.Println("No transactions available. Pausing mining...")
+		return params.MinerIdleInterval // Prevents high CPU usage when waiting for transactions
+	}
 
-			// Remove transactions from the mempool
-			miner.Mempool.RemoveTransactionsInBlock(minedBlock)
+	// Mine the block
+	if _, err := miner.MineBlock(ctx, template); err != nil {
+		if errors.Is(err, ErrPoWInterrupted) {
+			return 0 // Restart right away on the new tip or with the new settings
 		}
+		log.Println(err)
+		return params.MinerIdleInterval
+	}
+
+	// Pause to allow network sync before restarting
+	return params.MinerPauseInterval
+}
 
-		// Pause to allow network sync before restarting
-		time.Sleep(60 * time.Second)
+// wait pauses the mining loop for the given duration, or until the mining
+// settings change if negative. It returns false once the miner is stopped.
+func (miner *Miner) wait(d time.Duration) bool {
+	var timeout <-chan time.Time
+	if d >= 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-miner.ctx.Done():
+		return false
+	case <-miner.wake:
+		return true
+	case <-timeout:
+		return true
 	}
 }
 
-// PerformProofOfWork executes the proof of work algorithm
-func (miner *Miner) PerformProofOfWork(block *block.Block) *block.Block {
-	log.Printf("Mining block %s with difficulty %d...\n", block.BlockID, block.Difficulty)
-	prefix := strings.Repeat("0", block.Difficulty)
+// Generate mines n blocks right away with the packages paying the highest
+// fee rate, even if the mempool is empty, and returns their IDs. The mining
+// loop is interrupted until the blocks are mined.
+func (miner *Miner) Generate(n int) ([]string, error) {
+	// Interrupt the mining loop, which gives way until the blocks are mined
+	miner.mutex.Lock()
+	miner.generating++
+	miner.mutex.Unlock()
+	defer func() {
+		miner.mutex.Lock()
+		miner.generating--
+		miner.mutex.Unlock()
+		miner.notify()
+	}()
+	miner.StopPoW()
 
-	block.Nonce = 0
-	for {
-		select {
-		case <-miner.StopMining:
-			log.Println("Mining interrupted due to a new block.")
-			return nil
-		default:
-			blockHash := block.Hash()
-			if strings.HasPrefix(blockHash, prefix) {
-				block.BlockID = blockHash
-				log.Printf("Block mined: %s\n", block.BlockID)
-				return block
-			}
-			block.Nonce++
+	miner.powMutex.Lock()
+	defer miner.powMutex.Unlock()
+
+	blockIDs := make([]string, 0, n)
+	for len(blockIDs) < n {
+		ctx, cancel, _ := miner.newPoWContext(true)
+		template, err := miner.NewBlockTemplate()
+		if err != nil {
+			cancel()
+			return blockIDs, err
+		}
+		minedBlock, err := miner.MineBlock(ctx, template)
+		cancel()
+		if errors.Is(err, ErrPoWInterrupted) && miner.ctx.Err() == nil {
+			continue // Start over on the new tip or with the new settings
+		}
+		if err != nil {
+			return blockIDs, err
 		}
+		blockIDs = append(blockIDs, minedBlock.BlockID)
 	}
+	return blockIDs, nil
+}
+
+// MineBlock performs the proof of work on a block template, adds the block
+// to the blockchain and broadcasts it. The proof of work is interrupted once
+// the context is canceled.
+func (miner *Miner) MineBlock(ctx context.Context, template *BlockTemplate) (*block.Block, error) {
+	log.Printf("Block template: %d transactions, %s in fees, %d bytes\n", len(template.Block.Transactions)-1, template.Fees, template.Size)
+
+	// Perform Proof of Work
+	minedBlock := miner.PerformProofOfWork(ctx, template.Block)
+	if minedBlock == nil {
+		return nil, ErrPoWInterrupted
+	}
+
+	if err := miner.SubmitBlock(minedBlock); err != nil {
+		return nil, err
+	}
+	return minedBlock, nil
+}
+
+// SubmitBlock adds a block whose proof of work was performed to the
+// blockchain, broadcasts it and removes its transactions from the mempool
+func (miner *Miner) SubmitBlock(b *block.Block) error {
+	// Add Mined Block to Blockchain
+	if err := miner.Blockchain.AddBlock(b); err != nil {
+		return fmt.Errorf("failed to add block to blockchain: %v", err)
+	}
+
+	// Broadcast the Mined Block
+	miner.BroadcastBlock(b)
+
+	// Remove transactions from the mempool
+	miner.Mempool.RemoveTransactionsInBlock(b)
+
+	return nil
 }
 
 // BroadcastBlock sends the newly mined block to the network
@@ -113,16 +229,71 @@ func (miner *Miner) BroadcastBlock(b *block.Block) {
 	log.Printf("Broadcasted new block: %s", b.BlockID)
 }
 
-// StopPoW stops the proof of work process
+// newPoWContext returns the context of the next proof of work, canceled by
+// StopPoW, by Close, or once the main chain moves past its block. It returns
+// false while Generate runs, unless called by Generate.
+func (miner *Miner) newPoWContext(generate bool) (context.Context, context.CancelFunc, bool) {
+	miner.mutex.Lock()
+	defer miner.mutex.Unlock()
+
+	if !generate && miner.generating > 0 {
+		return nil, nil, false
+	}
+	ctx, cancel := context.WithCancel(miner.ctx)
+	miner.cancelPoW = cancel
+	miner.powParent = ""
+	return ctx, cancel, true
+}
+
+// trackPoWParent records the parent of the block of the proof of work in
+// progress, and stops it right away if the tip already moved while the
+// block template was assembled
+func (miner *Miner) trackPoWParent(prevHash string) {
+	miner.mutex.Lock()
+	miner.powParent = prevHash
+	miner.mutex.Unlock()
+
+	miner.stopStalePoW()
+}
+
+// watchChain checks the proof of work in progress against each event of the
+// main chain, until the subscription is stopped
+func (miner *Miner) watchChain(sub *blockchain.Subscription) {
+	for range sub.Events {
+		miner.stopStalePoW()
+	}
+}
+
+// stopStalePoW stops the proof of work in progress if its block no longer
+// extends the tip, so that the mining loop rebuilds its template
+func (miner *Miner) stopStalePoW() {
+	miner.mutex.Lock()
+	defer miner.mutex.Unlock()
+
+	if miner.powParent == "" {
+		return
+	}
+	if tip, _ := miner.Blockchain.GetTip(); tip.BlockID == miner.powParent {
+		return
+	}
+
+	log.Println("New tip, restarting PoW...")
+	miner.powParent = ""
+	miner.cancelPoW()
+}
+
+// StopPoW stops the proof of work in progress, if any
 func (miner *Miner) StopPoW() {
-	log.Println("Stopping PoW process...")
-	miner.StopMining <- true
+	miner.mutex.Lock()
+	cancel := miner.cancelPoW
+	miner.mutex.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
 }
 
-// Close closes the miner
+// Close stops the mining loop and the proof of work in progress
 func (miner *Miner) Close() {
-	miner.StopMining <- true
-	miner.StopRunning <- true
-	close(miner.StopMining)
-	close(miner.StopRunning)
+	miner.cancel()
 }
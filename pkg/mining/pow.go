@@ -0,0 +1,167 @@
+package mining
+
+import (
+	"context"
+	"crypto/sha256"
+	"log"
+	"math"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/block"
+)
+
+const (
+	TIMESTAMPUPDATEINTERVAL = 1 << 16 // Number of nonces a worker tries between two timestamp updates
+	STOPCHECKINTERVAL       = 1 << 10 // Number of nonces a worker tries between two checks for a stop
+)
+
+type powSolution struct {
+	Nonce     uint32 // Nonce meeting the target
+	Timestamp int64  // Timestamp of the header hashed with the nonce
+}
+
+// PerformProofOfWork searches for a nonce meeting the target of the block,
+// splitting the nonces across Threads workers. Once the nonces are exhausted,
+// the extra nonce of the coinbase is incremented and the search starts over.
+// It returns nil once the context is canceled or the block no longer
+// extends the tip.
+func (miner *Miner) PerformProofOfWork(ctx context.Context, b *block.Block) *block.Block {
+	miner.trackPoWParent(b.PrevHash)
+
+	threads := miner.GetThreads()
+	log.Printf("Mining block %s with target %08x on %d threads...\n", b.BlockID, b.Bits, threads)
+
+	var hashes atomic.Uint64
+	start := time.Now()
+	defer func() {
+		miner.updateHashrate(hashes.Load(), time.Since(start))
+	}()
+
+	for extraNonce := b.Transactions[0].ExtraNonce; ; extraNonce++ {
+		b.SetExtraNonce(extraNonce)
+
+		solution, stopped := miner.searchNonces(ctx, b.Header(), threads, &hashes, start)
+		if stopped {
+			log.Println("Mining interrupted.")
+			return nil
+		}
+		if solution != nil {
+			b.Nonce = solution.Nonce
+			b.Timestamp = solution.Timestamp
+			b.BlockID = b.Hash()
+			log.Printf("Block mined: %s\n", b.BlockID)
+			return b
+		}
+		log.Printf("Nonces exhausted, moving to extra nonce %d\n", extraNonce+1)
+	}
+}
+
+// searchNonces searches all the nonces of a header with the given number of
+// workers, and reports the solution found, if any, or whether the context
+// was canceled
+func (miner *Miner) searchNonces(ctx context.Context, header *block.Header, threads int, hashes *atomic.Uint64, start time.Time) (*powSolution, bool) {
+	stop := make(chan struct{})
+	solutions := make(chan *powSolution, threads)
+
+	// Give each worker its own range of nonces
+	var wg sync.WaitGroup
+	span := (uint64(math.MaxUint32) + 1) / uint64(threads)
+	for i := 0; i < threads; i++ {
+		first := uint64(i) * span
+		last := first + span - 1
+		if i == threads-1 {
+			last = math.MaxUint32
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			miner.searchNonceRange(header, uint32(first), uint32(last), stop, solutions, hashes)
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case solution := <-solutions:
+			close(stop)
+			<-done
+			return solution, false
+		case <-ctx.Done():
+			close(stop)
+			<-done
+			return nil, true
+		case <-done:
+			// All the nonces were tried, unless the last worker found a solution
+			select {
+			case solution := <-solutions:
+				return solution, false
+			default:
+				return nil, false
+			}
+		case <-ticker.C:
+			miner.updateHashrate(hashes.Load(), time.Since(start))
+		}
+	}
+}
+
+// searchNonceRange tries the nonces from first to last on a binary header
+// serialized once, patching only the nonce, and the timestamp now and then
+func (miner *Miner) searchNonceRange(header *block.Header, first, last uint32, stop <-chan struct{}, solutions chan<- *powSolution, hashes *atomic.Uint64) {
+	buf := header.Bytes()
+	timestamp := header.Timestamp
+	target := block.CompactToBig(header.Bits)
+	hashNum := new(big.Int)
+
+	attempts := uint64(0)
+	defer func() {
+		hashes.Add(attempts % STOPCHECKINTERVAL)
+	}()
+	for nonce := uint64(first); nonce <= uint64(last); nonce++ {
+		// Check for a stop, and account the hashes, every STOPCHECKINTERVAL nonces
+		if attempts > 0 && attempts%STOPCHECKINTERVAL == 0 {
+			hashes.Add(STOPCHECKINTERVAL)
+			select {
+			case <-stop:
+				return
+			default:
+			}
+		}
+
+		// Keep the timestamp current during a long search
+		if attempts%TIMESTAMPUPDATEINTERVAL == TIMESTAMPUPDATEINTERVAL-1 {
+			timestamp = max(timestamp, miner.Blockchain.NextBlockTimestamp())
+			block.PutHeaderTimestamp(buf, timestamp)
+		}
+
+		block.PutHeaderNonce(buf, uint32(nonce))
+		hash := sha256.Sum256(buf)
+		attempts++
+		if hashNum.SetBytes(hash[:]).Cmp(target) <= 0 {
+			solutions <- &powSolution{Nonce: uint32(nonce), Timestamp: timestamp}
+			return
+		}
+	}
+}
+
+// updateHashrate records the hashrate of the last proof of work search
+func (miner *Miner) updateHashrate(hashes uint64, elapsed time.Duration) {
+	if elapsed <= 0 {
+		return
+	}
+	miner.hashrate.Store(uint64(float64(hashes) / elapsed.Seconds()))
+}
+
+// Hashrate returns the number of hashes per second of the last proof of work search
+func (miner *Miner) Hashrate() uint64 {
+	return miner.hashrate.Load()
+}
@@ -0,0 +1,307 @@
+package stratum
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/big"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/block"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/mining"
+)
+
+const (
+	DIALTIMEOUT      = 10 * time.Second // Time allowed to connect to the server
+	HASHRATEINTERVAL = 10 * time.Second // Interval between two hashrate reports
+)
+
+type Client struct {
+	Address     string                   // Address of the stratum server
+	Worker      string                   // Name of the worker
+	Threads     int                      // Number of proof of work workers
+	Accepted    atomic.Int64             // Number of shares accepted
+	Rejected    atomic.Int64             // Number of shares rejected
+	conn        net.Conn                 // Connection to the server
+	scanner     *bufio.Scanner           // Reader of the messages of the server
+	extraNonce1 uint32                   // High 32 bits of the extra nonce, assigned by the server
+	shareBits   atomic.Uint32            // Compact share target
+	hashes      atomic.Uint64            // Number of hashes computed
+	nextID      atomic.Uint64            // ID of the next request
+	pending     map[uint64]*SubmitParams // Request ID -> Share awaiting its result
+	mutex       *sync.Mutex              // Mutex serializing the writes and protecting the pending shares
+}
+
+// NewClient creates a new stratum client
+func NewClient(address, worker string, threads int) *Client {
+	return &Client{
+		Address: address,
+		Worker:  worker,
+		Threads: max(threads, 1),
+		pending: make(map[uint64]*SubmitParams),
+		mutex:   &sync.Mutex{},
+	}
+}
+
+// Connect connects to the server, subscribes and authorizes the worker
+func (c *Client) Connect() error {
+	conn, err := net.DialTimeout("tcp", c.Address, DIALTIMEOUT)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %v", c.Address, err)
+	}
+	c.conn = conn
+	c.scanner = newMessageScanner(conn)
+
+	// Subscribe
+	var subscription SubscribeResult
+	if err := c.call(METHODSUBSCRIBE, struct{}{}, &subscription); err != nil {
+		conn.Close()
+		return err
+	}
+	c.extraNonce1 = subscription.ExtraNonce1
+
+	// Authorize the worker
+	if err := c.call(METHODAUTHORIZE, &AuthorizeParams{Worker: c.Worker}, nil); err != nil {
+		conn.Close()
+		return err
+	}
+
+	log.Printf("Connected to %s as %s with extra nonce prefix %08x\n", c.Address, c.Worker, c.extraNonce1)
+	return nil
+}
+
+// call sends a request and waits for its response. It is only used before
+// Run, when the server sends nothing else.
+func (c *Client) call(method string, params interface{}, result interface{}) error {
+	if err := c.send(method, params, nil); err != nil {
+		return err
+	}
+
+	if !c.scanner.Scan() {
+		return fmt.Errorf("connection closed during %s", method)
+	}
+	var msg Message
+	if err := json.Unmarshal(c.scanner.Bytes(), &msg); err != nil {
+		return fmt.Errorf("failed to decode response: %v", err)
+	}
+	if msg.Error != nil {
+		return msg.Error
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(msg.Result, result)
+}
+
+// send writes a request. A submitted share is registered before it is
+// written, so that its response always finds it.
+func (c *Client) send(method string, params interface{}, share *SubmitParams) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %v", method, err)
+	}
+	id := c.nextID.Add(1)
+	line, err := json.Marshal(&Message{ID: &id, Method: method, Params: data})
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %v", method, err)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if share != nil {
+		c.pending[id] = share
+	}
+	if _, err := c.conn.Write(append(line, '\n')); err != nil {
+		delete(c.pending, id)
+		return fmt.Errorf("failed to send %s: %v", method, err)
+	}
+	return nil
+}
+
+// Run mines the jobs sent by the server until the connection is closed
+func (c *Client) Run() error {
+	defer c.conn.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go c.reportHashrate(done)
+
+	var stop chan struct{}
+	defer func() {
+		if stop != nil {
+			close(stop)
+		}
+	}()
+
+	for c.scanner.Scan() {
+		var msg Message
+		if err := json.Unmarshal(c.scanner.Bytes(), &msg); err != nil {
+			log.Printf("Failed to decode message: %v\n", err)
+			continue
+		}
+
+		switch {
+		case msg.ID != nil:
+			c.handleResponse(&msg)
+
+		case msg.Method == METHODSETTARGET:
+			var params SetTargetParams
+			if err := json.Unmarshal(msg.Params, &params); err != nil {
+				log.Printf("Failed to decode share target: %v\n", err)
+				continue
+			}
+			c.shareBits.Store(params.Bits)
+			log.Printf("Share target set to %08x\n", params.Bits)
+
+		case msg.Method == METHODNOTIFY:
+			var job Job
+			if err := json.Unmarshal(msg.Params, &job); err != nil {
+				log.Printf("Failed to decode job: %v\n", err)
+				continue
+			}
+
+			// Drop the current job for the new one
+			if stop != nil {
+				close(stop)
+			}
+			stop = make(chan struct{})
+			log.Printf("New job %s on %s with target %08x\n", job.JobID, job.PrevHash, job.Bits)
+			go c.mine(&job, stop)
+		}
+	}
+
+	if err := c.scanner.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+// handleResponse accounts the result of a submitted share
+func (c *Client) handleResponse(msg *Message) {
+	c.mutex.Lock()
+	share := c.pending[*msg.ID]
+	delete(c.pending, *msg.ID)
+	c.mutex.Unlock()
+	if share == nil {
+		return
+	}
+
+	if msg.Error != nil {
+		c.Rejected.Add(1)
+		log.Printf("Share rejected for job %s: %s\n", share.JobID, msg.Error.Message)
+		return
+	}
+	c.Accepted.Add(1)
+	log.Printf("Share accepted for job %s (nonce %08x)\n", share.JobID, share.Nonce)
+}
+
+// mine searches the job on Threads workers, each trying its own extra nonces,
+// until a new job arrives or a block is found, which makes the job stale
+func (c *Client) mine(job *Job, stop <-chan struct{}) {
+	target := block.CompactToBig(c.shareBits.Load())
+
+	// Stop the workers on a new job or once the first block is found
+	solved := make(chan struct{})
+	solvedOnce := &sync.Once{}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-stop:
+			solvedOnce.Do(func() { close(solved) })
+		case <-done:
+		}
+	}()
+	defer close(done)
+
+	var wg sync.WaitGroup
+	for i := 0; i < c.Threads; i++ {
+		wg.Add(1)
+		go func(first uint32) {
+			defer wg.Done()
+			for extraNonce2 := first; ; extraNonce2 += uint32(c.Threads) {
+				if !c.searchNonces(job, extraNonce2, target, solved, solvedOnce) {
+					return
+				}
+			}
+		}(uint32(i))
+	}
+	wg.Wait()
+}
+
+// searchNonces tries all the nonces of the header of the job with the given
+// extra nonce, and submits the shares found. It returns false once stopped,
+// and stops the other workers once it finds a block.
+func (c *Client) searchNonces(job *Job, extraNonce2 uint32, target *big.Int, stop chan struct{}, stopOnce *sync.Once) bool {
+	coinbase := *job.Coinbase
+	coinbase.ExtraNonce = ExtraNonce(c.extraNonce1, extraNonce2)
+	header := &block.Header{
+		PrevHash:   job.PrevHash,
+		MerkleRoot: block.ComputeMerkleRootFromBranch(coinbase.GenerateTransactionID(), job.MerkleBranch),
+		Timestamp:  job.Timestamp,
+		Bits:       job.Bits,
+	}
+	buf := header.Bytes()
+	blockTarget := block.CompactToBig(job.Bits)
+	hashNum := new(big.Int)
+
+	for nonce := uint64(0); nonce <= math.MaxUint32; nonce++ {
+		// Check for a new job every STOPCHECKINTERVAL nonces
+		if nonce > 0 && nonce%mining.STOPCHECKINTERVAL == 0 {
+			c.hashes.Add(mining.STOPCHECKINTERVAL)
+			select {
+			case <-stop:
+				return false
+			default:
+			}
+		}
+
+		block.PutHeaderNonce(buf, uint32(nonce))
+		hash := sha256.Sum256(buf)
+		if hashNum.SetBytes(hash[:]).Cmp(target) <= 0 {
+			share := &SubmitParams{
+				Worker:      c.Worker,
+				JobID:       job.JobID,
+				ExtraNonce2: extraNonce2,
+				Timestamp:   job.Timestamp,
+				Nonce:       uint32(nonce),
+			}
+			if err := c.send(METHODSUBMIT, share, share); err != nil {
+				log.Printf("Failed to submit share: %v\n", err)
+			}
+
+			// The job is stale once its block is found
+			if hashNum.Cmp(blockTarget) <= 0 {
+				stopOnce.Do(func() { close(stop) })
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// reportHashrate logs the hashrate and the shares every HASHRATEINTERVAL
+func (c *Client) reportHashrate(done <-chan struct{}) {
+	ticker := time.NewTicker(HASHRATEINTERVAL)
+	defer ticker.Stop()
+
+	last := c.hashes.Load()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			hashes := c.hashes.Load()
+			log.Printf("Hashrate: %d H/s, %d shares accepted, %d rejected\n",
+				uint64(float64(hashes-last)/HASHRATEINTERVAL.Seconds()), c.Accepted.Load(), c.Rejected.Load())
+			last = hashes
+		}
+	}
+}
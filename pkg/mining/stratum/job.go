@@ -0,0 +1,82 @@
+package stratum
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/block"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/transaction"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/mining"
+)
+
+type job struct {
+	Job                                   // Job sent to the miners
+	Template        *mining.BlockTemplate // Block template the job was built from
+	ShareBits       uint32                // Compact share target
+	MempoolSequence uint64                // Sequence of the mempool when the template was assembled
+	CreatedAt       time.Time             // Time the job was created
+	shares          map[string]bool       // IDs of the blocks already submitted as shares
+}
+
+// newJob creates a job from a block template
+func newJob(jobID string, template *mining.BlockTemplate, shareBits uint32, mempoolSequence uint64, cleanJobs bool) *job {
+	b := template.Block
+	return &job{
+		Job: Job{
+			JobID:        jobID,
+			PrevHash:     b.PrevHash,
+			Coinbase:     b.Transactions[0],
+			MerkleBranch: block.ComputeMerkleBranch(b.Transactions),
+			Timestamp:    b.Timestamp,
+			Bits:         b.Bits,
+			CleanJobs:    cleanJobs,
+		},
+		Template:        template,
+		ShareBits:       shareBits,
+		MempoolSequence: mempoolSequence,
+		CreatedAt:       time.Now(),
+		shares:          make(map[string]bool),
+	}
+}
+
+// solve builds the block of the job with the given extra nonce, timestamp
+// and nonce, leaving the template untouched
+func (j *job) solve(extraNonce uint64, timestamp int64, nonce uint32) *block.Block {
+	coinbase := *j.Coinbase
+	coinbase.ExtraNonce = extraNonce
+	coinbase.TransactionID = coinbase.GenerateTransactionID()
+
+	transactions := make([]*transaction.Transaction, 0, len(j.Template.Block.Transactions))
+	transactions = append(transactions, &coinbase)
+	transactions = append(transactions, j.Template.Block.Transactions[1:]...)
+
+	b := &block.Block{
+		PrevHash:     j.PrevHash,
+		MerkleRoot:   block.ComputeMerkleRootFromBranch(coinbase.TransactionID, j.MerkleBranch),
+		Timestamp:    timestamp,
+		Nonce:        nonce,
+		Bits:         j.Bits,
+		Transactions: transactions,
+	}
+	b.BlockID = b.Hash()
+	return b
+}
+
+// shareTarget returns the compact share target, factor times easier than the
+// block target but never easier than MAXSHARETARGETBITS, nor harder than the
+// block target. On networks whose block target is already easier than
+// MAXSHARETARGETBITS, the share target is the block target, and every share
+// is a block.
+func shareTarget(bits uint32, factor int64) uint32 {
+	blockTarget := block.CompactToBig(bits)
+	maxTarget := block.CompactToBig(MAXSHARETARGETBITS)
+	if blockTarget.Cmp(maxTarget) >= 0 {
+		return bits
+	}
+
+	target := new(big.Int).Mul(blockTarget, big.NewInt(factor))
+	if target.Cmp(maxTarget) > 0 {
+		return MAXSHARETARGETBITS
+	}
+	return block.BigToCompact(target)
+}
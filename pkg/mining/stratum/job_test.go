@@ -0,0 +1,27 @@
+package stratum
+
+import "testing"
+
+func TestShareTarget(t *testing.T) {
+	tests := []struct {
+		name   string
+		bits   uint32
+		factor int64
+		want   uint32
+	}{
+		{name: "factor times easier", bits: 0x1d00ffff, factor: 256, want: 0x1e00ffff},
+		{name: "factor of one", bits: 0x1d00ffff, factor: 1, want: 0x1d00ffff},
+		{name: "capped at the easiest share target", bits: 0x1e0fffff, factor: 256, want: MAXSHARETARGETBITS},
+		{name: "reaching the easiest share target", bits: 0x1e00ffff, factor: 256, want: MAXSHARETARGETBITS},
+		{name: "block target at the easiest share target", bits: MAXSHARETARGETBITS, factor: 256, want: MAXSHARETARGETBITS},
+		{name: "block target easier than the easiest share target", bits: 0x207fffff, factor: 256, want: 0x207fffff},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := shareTarget(tt.bits, tt.factor); got != tt.want {
+				t.Errorf("shareTarget(%08x, %d) = %08x, want %08x", tt.bits, tt.factor, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package stratum
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/transaction"
+)
+
+const MAXMESSAGESIZE = 1 << 20 // Upper bound on the size of a message line
+
+// Methods called by the miners
+const (
+	METHODSUBSCRIBE = "mining.subscribe" // Opens a session and assigns its extra nonce prefix
+	METHODAUTHORIZE = "mining.authorize" // Registers a worker on the session
+	METHODSUBMIT    = "mining.submit"    // Submits a share
+)
+
+// Notifications sent by the server
+const (
+	METHODNOTIFY    = "mining.notify"     // Announces a new job
+	METHODSETTARGET = "mining.set_target" // Sets the share target of the next jobs
+)
+
+// Error codes
+const (
+	ERRUNKNOWN       = 20 // Malformed request or unknown method
+	ERRJOBNOTFOUND   = 21 // The job is unknown or stale
+	ERRDUPLICATE     = 22 // The share was already submitted
+	ERRLOWDIFFICULTY = 23 // The share does not meet the share target
+	ERRUNAUTHORIZED  = 24 // The worker is not authorized on the session
+	ERRNOTSUBSCRIBED = 25 // The session is not subscribed
+	ERRINVALIDSHARE  = 26 // The share is malformed
+)
+
+// Message is a line of the protocol: a request carries an ID and a method,
+// a response the ID of its request, and a notification a method without ID
+type Message struct {
+	ID     *uint64         `json:"id"`               // ID of the request, nil for a notification
+	Method string          `json:"method,omitempty"` // Called method
+	Params json.RawMessage `json:"params,omitempty"` // Parameters of the method
+	Result json.RawMessage `json:"result,omitempty"` // Result of a successful request
+	Error  *Error          `json:"error,omitempty"`  // Error of a failed request
+}
+
+type Error struct {
+	Code    int    `json:"code"`    // Error code
+	Message string `json:"message"` // Error description
+}
+
+// Error implements the error interface
+func (e *Error) Error() string {
+	return fmt.Sprintf("stratum error %d: %s", e.Code, e.Message)
+}
+
+type SubscribeResult struct {
+	ExtraNonce1 uint32 `json:"extra_nonce1"` // High 32 bits of the extra nonce, unique to the session
+}
+
+type AuthorizeParams struct {
+	Worker string `json:"worker"` // Name of the worker
+}
+
+type Job struct {
+	JobID        string                   `json:"job_id"`        // ID of the job
+	PrevHash     string                   `json:"prev_hash"`     // Hash of the tip the block extends
+	Coinbase     *transaction.Transaction `json:"coinbase"`      // Coinbase, before its extra nonce is set
+	MerkleBranch []string                 `json:"merkle_branch"` // Hashes the coinbase is paired with up to the Merkle root
+	Timestamp    int64                    `json:"timestamp"`     // Earliest timestamp of the block
+	Bits         uint32                   `json:"bits"`          // Compact target of the block
+	CleanJobs    bool                     `json:"clean_jobs"`    // Whether the previous jobs are stale
+}
+
+type SetTargetParams struct {
+	Bits uint32 `json:"bits"` // Compact share target
+}
+
+type SubmitParams struct {
+	Worker      string `json:"worker"`       // Name of the worker
+	JobID       string `json:"job_id"`       // ID of the job
+	ExtraNonce2 uint32 `json:"extra_nonce2"` // Low 32 bits of the extra nonce, chosen by the miner
+	Timestamp   int64  `json:"timestamp"`    // Timestamp of the header
+	Nonce       uint32 `json:"nonce"`        // Nonce of the header
+}
+
+// ExtraNonce combines the prefix assigned to a session with the part chosen
+// by the miner into the extra nonce of the coinbase
+func ExtraNonce(extraNonce1, extraNonce2 uint32) uint64 {
+	return uint64(extraNonce1)<<32 | uint64(extraNonce2)
+}
+
+// newMessageScanner returns a scanner reading one message per line
+func newMessageScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), MAXMESSAGESIZE)
+	return scanner
+}
+
+// newNotification creates a notification of the given method
+func newNotification(method string, params interface{}) (*Message, error) {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s: %v", method, err)
+	}
+	return &Message{Method: method, Params: data}, nil
+}
@@ -0,0 +1,403 @@
+package stratum
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/block"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/mining"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/mining/mempool"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/utils"
+)
+
+const (
+	JOBPOLLINTERVAL    = time.Second     // Interval between two checks of the tip and the mempool
+	JOBREFRESHINTERVAL = 5 * time.Second // Minimum age of a job before a change of the mempool replaces it
+	MAXJOBS            = 16              // Number of jobs of the current tip kept for late shares
+	SHARETARGETFACTOR  = 256             // Share target as a multiple of the block target
+	MAXSHARETARGETBITS = 0x1f00ffff      // Easiest share target, bounding the rate of shares of a miner
+)
+
+type WorkerStats struct {
+	Worker         string `json:"worker"`          // Name of the worker
+	AcceptedShares int64  `json:"accepted_shares"` // Number of shares accepted
+	RejectedShares int64  `json:"rejected_shares"` // Number of shares rejected
+	Blocks         int64  `json:"blocks"`          // Number of blocks found and accepted into the blockchain
+	LastShare      int64  `json:"last_share"`      // Unix time of the last accepted share
+}
+
+type StratumInfo struct {
+	Address   string         `json:"address"`    // Address the server listens on
+	Sessions  int            `json:"sessions"`   // Number of connected miners
+	JobID     string         `json:"job_id"`     // ID of the current job
+	ShareBits uint32         `json:"share_bits"` // Compact share target of the current job
+	Workers   []*WorkerStats `json:"workers"`    // Share accounting of every worker
+}
+
+type Server struct {
+	Address           string                  // Address the TCP server listens on
+	Blockchain        *blockchain.Blockchain  // Blockchain reference
+	Mempool           *mempool.Mempool        // Mempool reference
+	Miner             *mining.Miner           // Miner reference, assembling the templates and submitting the blocks
+	ShareTargetFactor int64                   // Share target as a multiple of the block target
+	listener          net.Listener            // Underlying TCP listener
+	sessions          map[*session]bool       // Connected miners
+	jobs              map[string]*job         // JobID -> Job of the current tip
+	currentJob        *job                    // Last job sent to the miners
+	workers           map[string]*WorkerStats // Worker name -> Share accounting
+	nextJobID         uint64                  // Number of jobs created
+	nextExtraNonce1   uint32                  // Extra nonce prefix of the last session
+	mutex             *sync.Mutex             // Mutex protecting the sessions, the jobs and the workers
+	jobMutex          *sync.Mutex             // Mutex serializing the creation of jobs
+	StopRunning       chan bool               // Channel to stop the server
+}
+
+// NewServer creates a new stratum server
+func NewServer(
+	address string,
+	blockchain *blockchain.Blockchain,
+	mempool *mempool.Mempool,
+	miner *mining.Miner,
+) *Server {
+	return &Server{
+		Address:           address,
+		Blockchain:        blockchain,
+		Mempool:           mempool,
+		Miner:             miner,
+		ShareTargetFactor: SHARETARGETFACTOR,
+		sessions:          make(map[*session]bool),
+		jobs:              make(map[string]*job),
+		workers:           make(map[string]*WorkerStats),
+		mutex:             &sync.Mutex{},
+		jobMutex:          &sync.Mutex{},
+		StopRunning:       make(chan bool),
+	}
+}
+
+// Listen binds the address of the server
+func (s *Server) Listen() error {
+	listener, err := net.Listen("tcp", s.Address)
+	if err != nil {
+		return fmt.Errorf("failed to start stratum server on %s: %v", s.Address, err)
+	}
+
+	s.mutex.Lock()
+	s.listener = listener
+	s.mutex.Unlock()
+	return nil
+}
+
+// Run accepts miner connections on the listener and keeps their jobs up to date
+func (s *Server) Run() {
+	s.mutex.Lock()
+	listener := s.listener
+	s.mutex.Unlock()
+
+	log.Printf("Stratum server listening on %s\n", s.Address)
+	go s.watchJobs()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("Failed to accept miner connection: %v\n", err)
+			continue
+		}
+		go s.handleSession(conn)
+	}
+}
+
+// watchJobs replaces the current job as soon as the tip changes, and polls
+// the mempool for new transactions
+func (s *Server) watchJobs() {
+	sub := s.Blockchain.Events.Subscribe()
+	defer sub.Unsubscribe()
+
+	ticker := time.NewTicker(JOBPOLLINTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.StopRunning:
+			return
+		case event := <-sub.Events:
+			if event.Type == blockchain.TIPCHANGED {
+				s.updateJob()
+			}
+		case <-ticker.C:
+			s.updateJob()
+		}
+	}
+}
+
+// updateJob creates a new job and sends it to the miners if the tip moved,
+// making the previous jobs stale, or if the pending transactions changed and
+// the current job is old enough
+func (s *Server) updateJob() {
+	s.jobMutex.Lock()
+	defer s.jobMutex.Unlock()
+
+	s.mutex.Lock()
+	current := s.currentJob
+	idle := len(s.sessions) == 0
+	s.mutex.Unlock()
+	if idle {
+		return
+	}
+
+	tip, _ := s.Blockchain.GetTip()
+	sequence := s.Mempool.GetSequence()
+	cleanJobs := current == nil || current.PrevHash != tip.BlockID
+	if !cleanJobs && (sequence == current.MempoolSequence || time.Since(current.CreatedAt) < JOBREFRESHINTERVAL) {
+		return
+	}
+
+	template, err := s.Miner.AssembleBlockTemplate()
+	if err != nil {
+		log.Printf("Failed to create stratum job: %v\n", err)
+		return
+	}
+
+	s.mutex.Lock()
+	s.nextJobID++
+	j := newJob(fmt.Sprintf("%x", s.nextJobID), template, shareTarget(template.Block.Bits, s.ShareTargetFactor), sequence, cleanJobs)
+	if cleanJobs {
+		s.jobs = make(map[string]*job)
+	}
+	s.jobs[j.JobID] = j
+	if s.nextJobID > MAXJOBS {
+		delete(s.jobs, fmt.Sprintf("%x", s.nextJobID-MAXJOBS))
+	}
+	s.currentJob = j
+	sessions := make([]*session, 0, len(s.sessions))
+	for sess := range s.sessions {
+		sessions = append(sessions, sess)
+	}
+	s.mutex.Unlock()
+
+	log.Printf("New stratum job %s at height %d: %d transactions, %s in fees\n", j.JobID, j.Coinbase.Height, len(template.Block.Transactions)-1, template.Fees)
+	for _, sess := range sessions {
+		if sess.hasWorkers() {
+			s.sendJob(sess, j)
+		}
+	}
+}
+
+// sendJob sends a job to a miner, and drops the miner if it cannot be reached
+func (s *Server) sendJob(sess *session, j *job) {
+	if err := sess.sendJob(j); err != nil {
+		log.Printf("Failed to send job to miner %s: %v\n", sess.conn.RemoteAddr(), err)
+		sess.conn.Close()
+	}
+}
+
+// sendCurrentJob sends the current job to a miner, creating it if needed
+func (s *Server) sendCurrentJob(sess *session) {
+	s.mutex.Lock()
+	current := s.currentJob
+	s.mutex.Unlock()
+
+	if current == nil {
+		s.updateJob()
+		return
+	}
+	s.sendJob(sess, current)
+}
+
+// handleSession serves the requests of a miner until it disconnects
+func (s *Server) handleSession(conn net.Conn) {
+	s.mutex.Lock()
+	s.nextExtraNonce1++ // The prefix 0 is left to the miner of the node
+	sess := newSession(conn, s.nextExtraNonce1)
+	s.sessions[sess] = true
+	s.mutex.Unlock()
+	log.Printf("Miner connected from %s\n", conn.RemoteAddr())
+
+	defer func() {
+		s.mutex.Lock()
+		delete(s.sessions, sess)
+		s.mutex.Unlock()
+		conn.Close()
+		log.Printf("Miner disconnected from %s\n", conn.RemoteAddr())
+	}()
+
+	scanner := newMessageScanner(conn)
+	for scanner.Scan() {
+		var msg Message
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil || msg.ID == nil || msg.Method == "" {
+			if err := sess.reply(msg.ID, nil, &Error{ERRUNKNOWN, "invalid request"}); err != nil {
+				return
+			}
+			continue
+		}
+
+		result, rpcErr := s.handleRequest(sess, &msg)
+		if err := sess.reply(msg.ID, result, rpcErr); err != nil {
+			log.Printf("Failed to reply to miner %s: %v\n", conn.RemoteAddr(), err)
+			return
+		}
+
+		// Start the miner on the current job once its first worker is authorized
+		if msg.Method == METHODAUTHORIZE && rpcErr == nil {
+			s.sendCurrentJob(sess)
+		}
+	}
+}
+
+// handleRequest dispatches a request of a miner
+func (s *Server) handleRequest(sess *session, msg *Message) (interface{}, *Error) {
+	switch msg.Method {
+	case METHODSUBSCRIBE:
+		sess.subscribe()
+		return &SubscribeResult{ExtraNonce1: sess.extraNonce1}, nil
+
+	case METHODAUTHORIZE:
+		var params AuthorizeParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil || params.Worker == "" {
+			return nil, &Error{ERRUNKNOWN, "invalid worker"}
+		}
+		if !sess.isSubscribed() {
+			return nil, &Error{ERRNOTSUBSCRIBED, "not subscribed"}
+		}
+		sess.authorize(params.Worker)
+
+		s.mutex.Lock()
+		if s.workers[params.Worker] == nil {
+			s.workers[params.Worker] = &WorkerStats{Worker: params.Worker}
+		}
+		s.mutex.Unlock()
+		log.Printf("Worker %s authorized from %s\n", params.Worker, sess.conn.RemoteAddr())
+		return true, nil
+
+	case METHODSUBMIT:
+		var params SubmitParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &Error{ERRINVALIDSHARE, "invalid share"}
+		}
+		return s.submitShare(sess, &params)
+
+	default:
+		return nil, &Error{ERRUNKNOWN, "unknown method: " + msg.Method}
+	}
+}
+
+// submitShare accounts a share of a worker, and submits the block to the
+// blockchain if the share also meets the block target
+func (s *Server) submitShare(sess *session, params *SubmitParams) (interface{}, *Error) {
+	if !sess.isAuthorized(params.Worker) {
+		return nil, &Error{ERRUNAUTHORIZED, "unauthorized worker"}
+	}
+
+	b, isBlock, rpcErr := s.checkShare(sess, params)
+
+	s.mutex.Lock()
+	stats := s.workers[params.Worker]
+	if rpcErr != nil {
+		stats.RejectedShares++
+		s.mutex.Unlock()
+		return nil, rpcErr
+	}
+	stats.AcceptedShares++
+	stats.LastShare = utils.GetCurrentTimeInUnix()
+	s.mutex.Unlock()
+
+	if !isBlock {
+		return true, nil
+	}
+
+	// The share is a full solution: submit the block
+	if err := s.Miner.SubmitBlock(b); err != nil {
+		log.Printf("Block %s from worker %s rejected: %v\n", b.BlockID, params.Worker, err)
+		return true, nil
+	}
+	log.Printf("Block %s found by worker %s\n", b.BlockID, params.Worker)
+
+	s.mutex.Lock()
+	stats.Blocks++
+	s.mutex.Unlock()
+
+	// Make the jobs on the previous tip stale right away; the miner of the
+	// node restarts once the blockchain publishes the new tip
+	s.updateJob()
+	return true, nil
+}
+
+// checkShare validates a share against its job and the share target, and
+// returns its block and whether the block meets the block target
+func (s *Server) checkShare(sess *session, params *SubmitParams) (*block.Block, bool, *Error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	j := s.jobs[params.JobID]
+	if j == nil {
+		return nil, false, &Error{ERRJOBNOTFOUND, "job not found"}
+	}
+
+	// The timestamp may move forward during the search, within the limits of the blockchain
+	maxTimestamp := s.Blockchain.TimeSource.AdjustedTime() + s.Blockchain.Params.MaxFutureBlockTime
+	if params.Timestamp < j.Timestamp || params.Timestamp > maxTimestamp {
+		return nil, false, &Error{ERRINVALIDSHARE, "timestamp out of range"}
+	}
+
+	b := j.solve(ExtraNonce(sess.extraNonce1, params.ExtraNonce2), params.Timestamp, params.Nonce)
+	if j.shares[b.BlockID] {
+		return nil, false, &Error{ERRDUPLICATE, "duplicate share"}
+	}
+
+	// A block is always a share, whatever the share target
+	isBlock := block.MeetsTarget(b.BlockID, j.Bits)
+	if !isBlock && !block.MeetsTarget(b.BlockID, j.ShareBits) {
+		return nil, false, &Error{ERRLOWDIFFICULTY, "low difficulty share"}
+	}
+	j.shares[b.BlockID] = true
+
+	return b, isBlock, nil
+}
+
+// GetInfo returns the sessions, the current job and the share accounting of the workers
+func (s *Server) GetInfo() *StratumInfo {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	info := &StratumInfo{
+		Address:  s.Address,
+		Sessions: len(s.sessions),
+		Workers:  make([]*WorkerStats, 0, len(s.workers)),
+	}
+	if s.currentJob != nil {
+		info.JobID = s.currentJob.JobID
+		info.ShareBits = s.currentJob.ShareBits
+	}
+	for _, stats := range s.workers {
+		statsCopy := *stats
+		info.Workers = append(info.Workers, &statsCopy)
+	}
+	sort.Slice(info.Workers, func(i, j int) bool {
+		return info.Workers[i].Worker < info.Workers[j].Worker
+	})
+	return info
+}
+
+// Close stops the server and disconnects the miners
+func (s *Server) Close() {
+	close(s.StopRunning)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	for sess := range s.sessions {
+		sess.conn.Close()
+	}
+}
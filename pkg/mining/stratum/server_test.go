@@ -0,0 +1,307 @@
+package stratum
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/json"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/block"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/chaincfg"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/mining"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/mining/mempool"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/p2p/gossip"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/p2p/membership"
+)
+
+const (
+	testBits    = 0x1e3fffff // Block target four times harder than MAXSHARETARGETBITS
+	testWorker  = "worker"   // Name of the worker of the test miner
+	testTimeout = 5 * time.Second
+
+	// Address of the miner of the node: the generator of P256
+	testAddress = "6b17d1f2e12c4247f8bce6e563a440f277037d812deb33a0f4a13945d898c296" +
+		"4fe342e2fe1a7f9b8ee7eb4a7c0f9e162bce33576b315ececbb6406837bf51f5"
+)
+
+// newTestServer creates a stratum server on a regtest blockchain whose block
+// target is harder than the share target, so that not every share is a block
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+
+	params := chaincfg.RegTestParams
+	params.InitialBits = testBits
+	mp := mempool.NewMempool()
+	bc := blockchain.NewBlockchain(&params, mp)
+
+	// The gossip manager has no members to send the found blocks to
+	membershipManager := membership.NewMembershipManager("127.0.0.1", nil)
+	gossipManager := gossip.NewGossipManager("127.0.0.1", nil, membershipManager)
+	miner := mining.NewMiner(testAddress, bc, gossipManager, mp)
+	return NewServer("127.0.0.1:0", bc, mp, miner)
+}
+
+type testMiner struct {
+	t           *testing.T
+	conn        net.Conn       // Miner end of the session
+	scanner     *bufio.Scanner // Reader of the messages of the server
+	extraNonce1 uint32         // Extra nonce prefix assigned by the server
+	shareBits   uint32         // Last share target notified
+	job         *Job           // Last job notified
+	nextID      uint64         // ID of the last request
+}
+
+// connect opens a session with the server, subscribes, authorizes the worker
+// and waits for the first job
+func connect(t *testing.T, s *Server) *testMiner {
+	t.Helper()
+
+	conn, serverConn := net.Pipe()
+	go s.handleSession(serverConn)
+	t.Cleanup(func() { conn.Close() })
+
+	m := &testMiner{t: t, conn: conn, scanner: newMessageScanner(conn)}
+	var subscription SubscribeResult
+	if err := m.call(METHODSUBSCRIBE, struct{}{}, &subscription); err != nil {
+		t.Fatalf("subscribe error = %v", err)
+	}
+	m.extraNonce1 = subscription.ExtraNonce1
+	if err := m.call(METHODAUTHORIZE, &AuthorizeParams{Worker: testWorker}, nil); err != nil {
+		t.Fatalf("authorize error = %v", err)
+	}
+
+	// The first job follows the authorization
+	for m.job == nil {
+		if msg := m.read(); msg.ID != nil {
+			t.Fatalf("unexpected response %d while waiting for a job", *msg.ID)
+		}
+	}
+	return m
+}
+
+// read reads the next message of the server, recording the notifications
+func (m *testMiner) read() *Message {
+	m.t.Helper()
+
+	m.conn.SetReadDeadline(time.Now().Add(testTimeout))
+	if !m.scanner.Scan() {
+		m.t.Fatalf("connection closed: %v", m.scanner.Err())
+	}
+	var msg Message
+	if err := json.Unmarshal(m.scanner.Bytes(), &msg); err != nil {
+		m.t.Fatalf("failed to decode message: %v", err)
+	}
+
+	switch msg.Method {
+	case METHODSETTARGET:
+		var params SetTargetParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			m.t.Fatalf("failed to decode share target: %v", err)
+		}
+		m.shareBits = params.Bits
+	case METHODNOTIFY:
+		var job Job
+		if err := json.Unmarshal(msg.Params, &job); err != nil {
+			m.t.Fatalf("failed to decode job: %v", err)
+		}
+		m.job = &job
+	}
+	return &msg
+}
+
+// call sends a request and reads the messages of the server up to its response
+func (m *testMiner) call(method string, params interface{}, result interface{}) error {
+	m.t.Helper()
+
+	data, err := json.Marshal(params)
+	if err != nil {
+		m.t.Fatalf("failed to encode %s: %v", method, err)
+	}
+	m.nextID++
+	line, err := json.Marshal(&Message{ID: &m.nextID, Method: method, Params: data})
+	if err != nil {
+		m.t.Fatalf("failed to encode %s: %v", method, err)
+	}
+	m.conn.SetWriteDeadline(time.Now().Add(testTimeout))
+	if _, err := m.conn.Write(append(line, '\n')); err != nil {
+		m.t.Fatalf("failed to send %s: %v", method, err)
+	}
+
+	for {
+		msg := m.read()
+		if msg.ID == nil {
+			continue
+		}
+		if *msg.ID != m.nextID {
+			m.t.Fatalf("response to request %d, want %d", *msg.ID, m.nextID)
+		}
+		if msg.Error != nil {
+			return msg.Error
+		}
+		if result != nil {
+			return json.Unmarshal(msg.Result, result)
+		}
+		return nil
+	}
+}
+
+// findShare searches the job for a header whose hash satisfies the condition
+// given the share and the block targets, as the client does
+func (m *testMiner) findShare(job *Job, found func(hash, shareTarget, blockTarget *big.Int) bool) *SubmitParams {
+	shareTarget := block.CompactToBig(m.shareBits)
+	blockTarget := block.CompactToBig(job.Bits)
+	hashNum := new(big.Int)
+
+	for extraNonce2 := uint32(0); ; extraNonce2++ {
+		coinbase := *job.Coinbase
+		coinbase.ExtraNonce = ExtraNonce(m.extraNonce1, extraNonce2)
+		header := &block.Header{
+			PrevHash:   job.PrevHash,
+			MerkleRoot: block.ComputeMerkleRootFromBranch(coinbase.GenerateTransactionID(), job.MerkleBranch),
+			Timestamp:  job.Timestamp,
+			Bits:       job.Bits,
+		}
+		buf := header.Bytes()
+
+		for nonce := uint32(0); nonce < 1<<20; nonce++ {
+			block.PutHeaderNonce(buf, nonce)
+			hash := sha256.Sum256(buf)
+			if found(hashNum.SetBytes(hash[:]), shareTarget, blockTarget) {
+				return &SubmitParams{
+					Worker:      testWorker,
+					JobID:       job.JobID,
+					ExtraNonce2: extraNonce2,
+					Timestamp:   job.Timestamp,
+					Nonce:       nonce,
+				}
+			}
+		}
+	}
+}
+
+// Conditions of findShare
+func isShare(hash, shareTarget, blockTarget *big.Int) bool {
+	return hash.Cmp(shareTarget) <= 0 && hash.Cmp(blockTarget) > 0
+}
+
+func isBlock(hash, shareTarget, blockTarget *big.Int) bool {
+	return hash.Cmp(blockTarget) <= 0
+}
+
+func isLowDifficulty(hash, shareTarget, blockTarget *big.Int) bool {
+	return hash.Cmp(shareTarget) > 0
+}
+
+// submit submits a share and returns the code of its error, or 0 if accepted
+func (m *testMiner) submit(params *SubmitParams) int {
+	m.t.Helper()
+
+	var accepted bool
+	if err := m.call(METHODSUBMIT, params, &accepted); err != nil {
+		rpcErr, ok := err.(*Error)
+		if !ok {
+			m.t.Fatalf("failed to decode submit result: %v", err)
+		}
+		return rpcErr.Code
+	}
+	if !accepted {
+		m.t.Fatalf("submit result = false without error")
+	}
+	return 0
+}
+
+// workerStats returns the share accounting of the test worker
+func workerStats(t *testing.T, s *Server) *WorkerStats {
+	t.Helper()
+
+	for _, stats := range s.GetInfo().Workers {
+		if stats.Worker == testWorker {
+			return stats
+		}
+	}
+	t.Fatalf("no stats for worker %s", testWorker)
+	return nil
+}
+
+func TestSubmitShare(t *testing.T) {
+	s := newTestServer(t)
+	m := connect(t, s)
+	if m.shareBits != MAXSHARETARGETBITS {
+		t.Fatalf("share target = %08x, want %08x", m.shareBits, MAXSHARETARGETBITS)
+	}
+
+	share := m.findShare(m.job, isShare)
+	earlyShare := *share
+	earlyShare.Timestamp = m.job.Timestamp - 1
+	unknownJob := *share
+	unknownJob.JobID = "unknown"
+
+	tests := []struct {
+		name     string
+		params   *SubmitParams
+		wantCode int
+	}{
+		{name: "accepted share", params: share, wantCode: 0},
+		{name: "duplicate share", params: share, wantCode: ERRDUPLICATE},
+		{name: "timestamp below the job's", params: &earlyShare, wantCode: ERRINVALIDSHARE},
+		{name: "unknown job", params: &unknownJob, wantCode: ERRJOBNOTFOUND},
+		{name: "low difficulty", params: m.findShare(m.job, isLowDifficulty), wantCode: ERRLOWDIFFICULTY},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := m.submit(tt.params); code != tt.wantCode {
+				t.Errorf("submit() error code = %d, want %d", code, tt.wantCode)
+			}
+		})
+	}
+
+	// A share below the block target is not submitted to the chain
+	if tip, height := s.Blockchain.GetTip(); height != 0 {
+		t.Errorf("tip = %s at height %d, want the genesis block", tip.BlockID, height)
+	}
+	stats := workerStats(t, s)
+	if stats.AcceptedShares != 1 || stats.RejectedShares != 4 || stats.Blocks != 0 {
+		t.Errorf("stats = %d accepted, %d rejected, %d blocks, want 1, 4 and 0",
+			stats.AcceptedShares, stats.RejectedShares, stats.Blocks)
+	}
+}
+
+func TestSubmitBlock(t *testing.T) {
+	s := newTestServer(t)
+	m := connect(t, s)
+	job := m.job
+	lateShare := m.findShare(job, isShare)
+
+	// A share meeting the block target is accepted and its block becomes the tip
+	solution := m.findShare(job, isBlock)
+	if code := m.submit(solution); code != 0 {
+		t.Fatalf("submit() of the block error code = %d", code)
+	}
+	tip, height := s.Blockchain.GetTip()
+	if height != 1 || tip.PrevHash != job.PrevHash || tip.Nonce != solution.Nonce {
+		t.Fatalf("tip = %s at height %d, want the block of the share at height 1", tip.BlockID, height)
+	}
+	if got := tip.Transactions[0].ExtraNonce; got != ExtraNonce(m.extraNonce1, solution.ExtraNonce2) {
+		t.Errorf("extra nonce of the coinbase = %x, want the one of the share", got)
+	}
+	if stats := workerStats(t, s); stats.AcceptedShares != 1 || stats.Blocks != 1 {
+		t.Errorf("stats = %d accepted, %d blocks, want 1 and 1", stats.AcceptedShares, stats.Blocks)
+	}
+
+	// The miner is moved to a job on the new tip, and the shares of the
+	// previous tip are stale
+	if m.job.JobID == job.JobID || m.job.PrevHash != tip.BlockID || !m.job.CleanJobs {
+		t.Errorf("job after the block = %s on %s, want a clean job on %s", m.job.JobID, m.job.PrevHash, tip.BlockID)
+	}
+	if code := m.submit(lateShare); code != ERRJOBNOTFOUND {
+		t.Errorf("submit() of a stale share error code = %d, want %d", code, ERRJOBNOTFOUND)
+	}
+	if code := m.submit(m.findShare(m.job, isShare)); code != 0 {
+		t.Errorf("submit() of a share of the new job error code = %d", code)
+	}
+}
@@ -0,0 +1,124 @@
+package stratum
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const WRITETIMEOUT = 10 * time.Second // Time allowed to write a message to a miner
+
+type session struct {
+	conn        net.Conn        // Connection of the miner
+	extraNonce1 uint32          // High 32 bits of the extra nonce, unique to the session
+	subscribed  bool            // Whether the miner subscribed
+	workers     map[string]bool // Names of the authorized workers
+	shareBits   uint32          // Share target last sent to the miner
+	mutex       *sync.Mutex     // Mutex serializing the writes and protecting the state
+}
+
+// newSession creates the session of a miner connection
+func newSession(conn net.Conn, extraNonce1 uint32) *session {
+	return &session{
+		conn:        conn,
+		extraNonce1: extraNonce1,
+		workers:     make(map[string]bool),
+		mutex:       &sync.Mutex{},
+	}
+}
+
+// subscribe marks the session as subscribed
+func (sess *session) subscribe() {
+	sess.mutex.Lock()
+	defer sess.mutex.Unlock()
+
+	sess.subscribed = true
+}
+
+// isSubscribed checks if the miner subscribed
+func (sess *session) isSubscribed() bool {
+	sess.mutex.Lock()
+	defer sess.mutex.Unlock()
+
+	return sess.subscribed
+}
+
+// authorize authorizes a worker on the session
+func (sess *session) authorize(worker string) {
+	sess.mutex.Lock()
+	defer sess.mutex.Unlock()
+
+	sess.workers[worker] = true
+}
+
+// isAuthorized checks if the worker is authorized on the session
+func (sess *session) isAuthorized(worker string) bool {
+	sess.mutex.Lock()
+	defer sess.mutex.Unlock()
+
+	return sess.workers[worker]
+}
+
+// hasWorkers checks if a worker is authorized on the session, so that it receives jobs
+func (sess *session) hasWorkers() bool {
+	sess.mutex.Lock()
+	defer sess.mutex.Unlock()
+
+	return len(sess.workers) > 0
+}
+
+// reply answers a request
+func (sess *session) reply(id *uint64, result interface{}, rpcErr *Error) error {
+	msg := &Message{ID: id, Error: rpcErr}
+	if rpcErr == nil {
+		data, err := json.Marshal(result)
+		if err != nil {
+			return fmt.Errorf("failed to encode result: %v", err)
+		}
+		msg.Result = data
+	}
+
+	sess.mutex.Lock()
+	defer sess.mutex.Unlock()
+
+	return sess.write(msg)
+}
+
+// sendJob sends a job to the miner, preceded by its share target if it changed
+func (sess *session) sendJob(j *job) error {
+	notify, err := newNotification(METHODNOTIFY, &j.Job)
+	if err != nil {
+		return err
+	}
+
+	sess.mutex.Lock()
+	defer sess.mutex.Unlock()
+
+	if sess.shareBits != j.ShareBits {
+		setTarget, err := newNotification(METHODSETTARGET, &SetTargetParams{Bits: j.ShareBits})
+		if err != nil {
+			return err
+		}
+		if err := sess.write(setTarget); err != nil {
+			return err
+		}
+		sess.shareBits = j.ShareBits
+	}
+	return sess.write(notify)
+}
+
+// write writes a message as a line; the caller must hold the mutex
+func (sess *session) write(msg *Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %v", err)
+	}
+
+	sess.conn.SetWriteDeadline(time.Now().Add(WRITETIMEOUT))
+	if _, err := sess.conn.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write message: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package mining
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/amount"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/block"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/transaction"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/mining/mempool"
+)
+
+type BlockTemplate struct {
+	Block     *block.Block  // Block to mine, starting with its coinbase
+	Fees      amount.Amount // Total fees of the transactions
+	Size      int           // Size of the block in bytes
+	SigOpCost int           // Total sigop cost of the transactions
+}
+
+type txPackage struct {
+	Entry     *mempool.TxEntry // Entry of the transaction
+	Ancestors map[string]bool  // IDs of the pending ancestors not selected yet
+	Fees      amount.Amount    // Fees of the transaction and of its ancestors not selected yet
+	Size      int              // Size of the transaction and of its ancestors not selected yet
+	SigOpCost int              // Sigop cost of the transaction and of its ancestors not selected yet
+}
+
+// feeRate returns the fee rate of the package
+func (p *txPackage) feeRate() amount.Amount {
+	return p.Fees / amount.Amount(p.Size)
+}
+
+// NewBlockTemplate assembles the next block for the miner to mine and
+// records it as the last template
+func (miner *Miner) NewBlockTemplate() (*BlockTemplate, error) {
+	template, err := miner.AssembleBlockTemplate()
+	if err != nil {
+		return nil, err
+	}
+
+	miner.mutex.Lock()
+	miner.lastTemplate = template
+	miner.mutex.Unlock()
+
+	return template, nil
+}
+
+// AssembleBlockTemplate assembles a block extending the tip from the pending
+// transactions and checks that it is valid before its proof of work is performed
+func (miner *Miner) AssembleBlockTemplate() (*BlockTemplate, error) {
+	transactions := miner.SelectTransactions()
+
+	// Create the block
+	b, err := miner.Blockchain.NewBlock(transactions, miner.GetAddress())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create block: %v", err)
+	}
+
+	// Validate the block against the tip
+	if err := miner.Blockchain.ValidateBlockTemplate(b); err != nil {
+		return nil, fmt.Errorf("invalid block template: %v", err)
+	}
+
+	template := &BlockTemplate{
+		Block: b,
+		Size:  b.Size(),
+	}
+	for _, tx := range transactions {
+		if template.Fees, err = template.Fees.Add(tx.Fee); err != nil {
+			return nil, err
+		}
+		template.SigOpCost += tx.SigOpCost()
+	}
+	return template, nil
+}
+
+// LastTemplate returns the last block template assembled, or nil
+func (miner *Miner) LastTemplate() *BlockTemplate {
+	miner.mutex.Lock()
+	defer miner.mutex.Unlock()
+
+	return miner.lastTemplate
+}
+
+// SelectTransactions selects up to N pending transactions that fit within the
+// size and sigop cost limits of a block. A transaction is selected together
+// with its pending ancestors, and the packages paying the highest fee rate go
+// first, so that a child paying a high fee pulls its parents into the block.
+// Parents are placed before their children.
+func (miner *Miner) SelectTransactions() []*transaction.Transaction {
+	params := miner.Blockchain.Params
+	entries, ancestors := miner.Mempool.GetEntriesWithAncestors()
+
+	// Build the package of every pending transaction
+	packages := make(map[string]*txPackage, len(entries))
+	for txID, entry := range entries {
+		p := &txPackage{
+			Entry:     entry,
+			Ancestors: make(map[string]bool),
+			Fees:      entry.Tx.Fee,
+			Size:      entry.Size,
+			SigOpCost: entry.Tx.SigOpCost(),
+		}
+		for _, ancestorID := range ancestors[txID] {
+			ancestor := entries[ancestorID]
+			p.Ancestors[ancestorID] = true
+			p.Fees += ancestor.Tx.Fee
+			p.Size += ancestor.Size
+			p.SigOpCost += ancestor.Tx.SigOpCost()
+		}
+		packages[txID] = p
+	}
+
+	size, sigOpCost := BLOCKRESERVEDSIZE, 0
+	selected := make([]*transaction.Transaction, 0)
+	for len(selected) < miner.NTransactions && len(packages) > 0 {
+		best := bestPackage(packages)
+		delete(packages, best.Entry.Tx.TransactionID)
+
+		// Skip the packages that would exceed a limit
+		nTransactions := len(best.Ancestors) + 1
+		packageSize := best.Size + nTransactions // Separators in the list of transactions
+		if len(selected)+nTransactions > miner.NTransactions ||
+			size+packageSize > params.MaxBlockSize ||
+			sigOpCost+best.SigOpCost > params.MaxBlockSigOpCost {
+			continue
+		}
+
+		// Add the package, parents before children: an ancestor always has
+		// fewer pending ancestors than its descendants
+		group := []string{best.Entry.Tx.TransactionID}
+		for ancestorID := range best.Ancestors {
+			group = append(group, ancestorID)
+		}
+		sort.Slice(group, func(i, j int) bool {
+			if len(ancestors[group[i]]) != len(ancestors[group[j]]) {
+				return len(ancestors[group[i]]) < len(ancestors[group[j]])
+			}
+			return group[i] < group[j]
+		})
+		for _, txID := range group {
+			selected = append(selected, entries[txID].Tx)
+			delete(packages, txID)
+		}
+		size += packageSize
+		sigOpCost += best.SigOpCost
+
+		// Take the selected transactions out of the packages of their descendants
+		for _, p := range packages {
+			for _, txID := range group {
+				if p.Ancestors[txID] {
+					delete(p.Ancestors, txID)
+					p.Fees -= entries[txID].Tx.Fee
+					p.Size -= entries[txID].Size
+					p.SigOpCost -= entries[txID].Tx.SigOpCost()
+				}
+			}
+		}
+	}
+	return selected
+}
+
+// bestPackage returns the package paying the highest fee rate
+func bestPackage(packages map[string]*txPackage) *txPackage {
+	var best *txPackage
+	for _, p := range packages {
+		if best == nil || p.feeRate() > best.feeRate() ||
+			(p.feeRate() == best.feeRate() && p.Entry.Tx.TransactionID < best.Entry.Tx.TransactionID) {
+			best = p
+		}
+	}
+	return best
+}
@@ -0,0 +1,81 @@
+package network
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	PROTOCOLVERSION = 1       // Version of the wire protocol
+	FRAMEHEADERSIZE = 14      // Magic (4) + version (2) + length (4) + checksum (4)
+	MAXFRAMESIZE    = 4 << 20 // Upper bound on the payload size of a frame: a message carries at most one 1 MB block, which escaping at most doubles
+)
+
+// WriteFrame writes a payload as a single frame starting with the network magic
+func WriteFrame(w io.Writer, magic [4]byte, payload []byte) error {
+	if len(payload) > MAXFRAMESIZE {
+		return fmt.Errorf("frame too large: %d bytes", len(payload))
+	}
+
+	// Build the frame header
+	frame := make([]byte, FRAMEHEADERSIZE+len(payload))
+	copy(frame[0:4], magic[:])
+	binary.BigEndian.PutUint16(frame[4:6], PROTOCOLVERSION)
+	binary.BigEndian.PutUint32(frame[6:10], uint32(len(payload)))
+	copy(frame[10:14], frameChecksum(payload))
+	copy(frame[FRAMEHEADERSIZE:], payload)
+
+	// Write the header and the payload in one call
+	if _, err := w.Write(frame); err != nil {
+		return fmt.Errorf("failed to write frame: %v", err)
+	}
+	return nil
+}
+
+// ReadFrame reads a complete frame and returns its payload. It returns
+// io.EOF if the stream ends cleanly between two frames, and an error if the
+// frame was sent by a node of another network.
+func ReadFrame(r io.Reader, magic [4]byte) ([]byte, error) {
+	// Read the frame header
+	header := make([]byte, FRAMEHEADERSIZE)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("failed to read frame header: %v", err)
+	}
+
+	// Check the header fields
+	if !bytes.Equal(header[0:4], magic[:]) {
+		return nil, fmt.Errorf("invalid magic: %x", header[0:4])
+	}
+	if version := binary.BigEndian.Uint16(header[4:6]); version != PROTOCOLVERSION {
+		return nil, fmt.Errorf("unsupported protocol version: %d", version)
+	}
+	length := binary.BigEndian.Uint32(header[6:10])
+	if length > MAXFRAMESIZE {
+		return nil, fmt.Errorf("frame too large: %d bytes", length)
+	}
+
+	// Read the payload until the frame is complete, growing the buffer with
+	// the bytes received rather than trusting the unauthenticated length
+	var payload bytes.Buffer
+	if _, err := io.CopyN(&payload, r, int64(length)); err != nil {
+		return nil, fmt.Errorf("failed to read frame payload: %v", err)
+	}
+	if !bytes.Equal(frameChecksum(payload.Bytes()), header[10:14]) {
+		return nil, fmt.Errorf("frame checksum mismatch")
+	}
+
+	return payload.Bytes(), nil
+}
+
+// frameChecksum returns the first 4 bytes of the double SHA256 hash of the payload
+func frameChecksum(payload []byte) []byte {
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
+	return second[:4]
+}
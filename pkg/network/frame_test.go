@@ -0,0 +1,132 @@
+package network_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/chaincfg"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/message"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/network"
+)
+
+// writeFrame returns the bytes of a frame carrying the payload
+func writeFrame(t *testing.T, payload []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := network.WriteFrame(&buf, testMagic, payload); err != nil {
+		t.Fatalf("WriteFrame() error = %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestFrameRoundTrip(t *testing.T) {
+	payloads := [][]byte{
+		[]byte(`{"type":"HEARTBEAT"}`),
+		{},
+		bytes.Repeat([]byte{0xff}, 64<<10),
+	}
+
+	// Frames are read back to back, then the stream ends cleanly
+	var stream bytes.Buffer
+	for _, payload := range payloads {
+		stream.Write(writeFrame(t, payload))
+	}
+	for i, want := range payloads {
+		got, err := network.ReadFrame(&stream, testMagic)
+		if err != nil {
+			t.Fatalf("ReadFrame() of frame %d error = %v", i, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("payload of frame %d = %d bytes, want %d bytes", i, len(got), len(want))
+		}
+	}
+	if _, err := network.ReadFrame(&stream, testMagic); err != io.EOF {
+		t.Errorf("ReadFrame() at the end of the stream error = %v, want io.EOF", err)
+	}
+}
+
+func TestReadFrameRejects(t *testing.T) {
+	payload := []byte(`{"type":"HEARTBEAT"}`)
+
+	tests := []struct {
+		name    string
+		frame   func(frame []byte) []byte // Corrupts a valid frame
+		wantErr string
+	}{
+		{
+			name:    "bad magic",
+			frame:   func(frame []byte) []byte { frame[0] ^= 0xff; return frame },
+			wantErr: "invalid magic",
+		},
+		{
+			name:    "unsupported version",
+			frame:   func(frame []byte) []byte { frame[5]++; return frame },
+			wantErr: "unsupported protocol version",
+		},
+		{
+			name:    "bad checksum",
+			frame:   func(frame []byte) []byte { frame[len(frame)-1] ^= 0xff; return frame },
+			wantErr: "checksum mismatch",
+		},
+		{
+			name:    "truncated header",
+			frame:   func(frame []byte) []byte { return frame[:network.FRAMEHEADERSIZE-1] },
+			wantErr: "failed to read frame header",
+		},
+		{
+			name:    "truncated payload",
+			frame:   func(frame []byte) []byte { return frame[:len(frame)-1] },
+			wantErr: "failed to read frame payload",
+		},
+		{
+			// Only the header is sent: the length is rejected before any payload is read
+			name: "oversized length",
+			frame: func(frame []byte) []byte {
+				binary.BigEndian.PutUint32(frame[6:10], network.MAXFRAMESIZE+1)
+				return frame[:network.FRAMEHEADERSIZE]
+			},
+			wantErr: "frame too large",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame := tt.frame(writeFrame(t, payload))
+			_, err := network.ReadFrame(bytes.NewReader(frame), testMagic)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ReadFrame() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWriteFrameRejectsOversizedPayload(t *testing.T) {
+	var buf bytes.Buffer
+	if err := network.WriteFrame(&buf, testMagic, make([]byte, network.MAXFRAMESIZE+1)); err == nil {
+		t.Errorf("WriteFrame() of %d bytes succeeded", network.MAXFRAMESIZE+1)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("WriteFrame() wrote %d bytes of an oversized frame", buf.Len())
+	}
+}
+
+func TestFrameFitsLargestBlockMessage(t *testing.T) {
+	// A block of the maximum size made only of quotes, each of which is
+	// escaped in the message JSON
+	block := strings.Repeat(`"`, chaincfg.MainNetParams.MaxBlockSize)
+	msg := message.NewMessage(message.BLOCK, "127.0.0.1:8333", "127.0.0.1:8334", block)
+	msg.PublicKey = strings.Repeat("ab", 64)
+	msg.Signature = strings.Repeat("cd", 64)
+
+	data, err := msg.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	if len(data) > network.MAXFRAMESIZE {
+		t.Errorf("largest block message = %d bytes, exceeds MAXFRAMESIZE %d", len(data), network.MAXFRAMESIZE)
+	}
+}
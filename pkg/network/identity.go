@@ -0,0 +1,321 @@
+package network
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/message"
+)
+
+const (
+	MAXMISBEHAVIOR   = 10               // Misbehavior count at which a peer is disconnected
+	MAXHELDMESSAGES  = 16               // Messages held per inbound peer until its address is verified
+	MAXCHALLENGES    = 3                // Challenges an inbound peer may trigger over its connection
+	CHALLENGETIMEOUT = 10 * time.Second // Time allowed to answer a challenge
+)
+
+var ErrUnverifiedSender = errors.New("sender is being verified")
+
+type challenge struct {
+	Nonce     string             // Random nonce sent to the claimed address
+	Address   string             // Address claimed by the inbound peer
+	PublicKey string             // Key the inbound peer signed with
+	Peer      *Peer              // Inbound peer claiming the address
+	Messages  []*message.Message // Messages held until the address is verified
+	ExpiresAt time.Time          // Time the challenge expires
+}
+
+type Signer interface {
+	GetAddress() string               // Public key identifying the signer
+	Sign(hash string) (string, error) // Signs a hex-encoded hash
+}
+
+// SetIdentity sets the address and the key every outgoing message is signed with
+func (pm *PeerManager) SetIdentity(address string, signer Signer) {
+	pm.Mutex.Lock()
+	defer pm.Mutex.Unlock()
+
+	pm.Address = address
+	pm.Signer = signer
+}
+
+// signMessage returns a copy of the message sent and signed by this node
+func (pm *PeerManager) signMessage(msg *message.Message) (*message.Message, error) {
+	pm.Mutex.RLock()
+	address, signer := pm.Address, pm.Signer
+	pm.Mutex.RUnlock()
+
+	signed := *msg
+	if signer == nil {
+		return &signed, nil
+	}
+
+	signed.Sender = address
+	signed.PublicKey = signer.GetAddress()
+	signature, err := signer.Sign(signed.Hash())
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign message: %v", err)
+	}
+	signed.Signature = signature
+	return &signed, nil
+}
+
+// Authenticate checks the signature of a received message against the
+// identity claimed by its sender. An address is bound to a key only once
+// the key proved it is reachable at that address: either the message came
+// over a connection dialed to the address, or the address answered a
+// challenge sent to it with a signature by the key. Only an address on the
+// IP the inbound peer connects from is challenged, at most MAXCHALLENGES
+// times per connection, so that peers cannot make this node dial third
+// parties. Until then, the messages of an inbound peer are held and
+// ErrUnverifiedSender is returned; they are delivered again once the
+// challenge is answered. A verified inbound peer is
+// re-keyed by its listening address, so that replies are sent back over the
+// same connection.
+func (pm *PeerManager) Authenticate(msg *message.Message) error {
+	if msg.Sender == "" {
+		return fmt.Errorf("message has no sender")
+	}
+	if err := msg.VerifySignature(); err != nil {
+		return err
+	}
+
+	pm.Mutex.Lock()
+	peer := pm.Peers[msg.Origin]
+
+	// An outbound peer can only speak for the address it was dialed at,
+	// which binds the address to its key
+	if peer != nil && !peer.Inbound {
+		defer pm.Mutex.Unlock()
+		if msg.Sender != peer.Address {
+			return fmt.Errorf("sender %s does not match peer %s", msg.Sender, peer.Address)
+		}
+		return pm.bindIdentity(msg.Sender, msg.PublicKey)
+	}
+
+	// Check the key bound to the sender
+	key, bound := pm.Identities[msg.Sender]
+	if bound {
+		defer pm.Mutex.Unlock()
+		if key != msg.PublicKey {
+			return fmt.Errorf("sender %s signed with an unknown key", msg.Sender)
+		}
+		if peer != nil {
+			pm.rekeyPeer(peer, msg.Sender)
+		}
+		return nil
+	}
+	if peer == nil || msg.Sender == pm.Address {
+		pm.Mutex.Unlock()
+		return fmt.Errorf("sender %s is not verified", msg.Sender)
+	}
+
+	// Hold the message and challenge the claimed address
+	challenge, created, err := pm.holdMessage(peer, msg)
+	pm.Mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	if created {
+		log.Printf("Challenging %s claimed by inbound peer %s\n", msg.Sender, peer.Address)
+		request := message.NewMessage(message.CHALLENGE, pm.Address, msg.Sender, challenge.Nonce)
+		if err := pm.Send(msg.Sender, request); err != nil {
+			log.Printf("Failed to challenge %s: %v\n", msg.Sender, err)
+		}
+	}
+	return ErrUnverifiedSender
+}
+
+// bindIdentity binds an address to a key, unless it is bound to another
+// key; the caller holds the mutex
+func (pm *PeerManager) bindIdentity(address, publicKey string) error {
+	if key, ok := pm.Identities[address]; ok && key != publicKey {
+		return fmt.Errorf("sender %s signed with an unknown key", address)
+	}
+	pm.Identities[address] = publicKey
+	return nil
+}
+
+// rekeyPeer keys an inbound peer by its verified listening address, unless
+// another peer already has it. Only the key changes: Address is read
+// without the mutex and stays the remote address of the connection. The
+// caller holds the mutex.
+func (pm *PeerManager) rekeyPeer(peer *Peer, address string) {
+	key := peer.GetAddress()
+	if !peer.Inbound || key == address {
+		return
+	}
+	if _, ok := pm.Peers[address]; ok {
+		return
+	}
+	if pm.Peers[key] == peer {
+		delete(pm.Peers, key)
+	}
+	peer.listenAddress.Store(&address)
+	pm.Peers[address] = peer
+}
+
+// holdMessage holds a message of an inbound peer until the address it
+// claims is verified. It reports whether a new challenge was created; the
+// caller holds the mutex.
+func (pm *PeerManager) holdMessage(peer *Peer, msg *message.Message) (*challenge, bool, error) {
+	pm.pruneChallenges()
+
+	if c := peer.challenge; c != nil {
+		if c.Address != msg.Sender || c.PublicKey != msg.PublicKey {
+			return nil, false, fmt.Errorf("sender %s does not match the identity %s being verified", msg.Sender, c.Address)
+		}
+		if len(c.Messages) >= MAXHELDMESSAGES {
+			return nil, false, fmt.Errorf("too many messages held for %s", msg.Sender)
+		}
+		c.Messages = append(c.Messages, msg)
+		return c, false, nil
+	}
+
+	// Only dial the IP the peer connects from, and only a few times
+	if !sameIP(msg.Sender, peer.Address) {
+		return nil, false, fmt.Errorf("sender %s is not on the IP of peer %s", msg.Sender, peer.Address)
+	}
+	if peer.challenges >= MAXCHALLENGES {
+		return nil, false, fmt.Errorf("too many challenges by peer %s", peer.Address)
+	}
+	peer.challenges++
+
+	nonce, err := newChallengeNonce()
+	if err != nil {
+		return nil, false, err
+	}
+	c := &challenge{
+		Nonce:     nonce,
+		Address:   msg.Sender,
+		PublicKey: msg.PublicKey,
+		Peer:      peer,
+		Messages:  []*message.Message{msg},
+		ExpiresAt: time.Now().Add(CHALLENGETIMEOUT),
+	}
+	pm.Challenges[nonce] = c
+	peer.challenge = c
+	return c, true, nil
+}
+
+// pruneChallenges drops the expired challenges and their held messages; the
+// caller holds the mutex
+func (pm *PeerManager) pruneChallenges() {
+	now := time.Now()
+	for nonce, c := range pm.Challenges {
+		if now.After(c.ExpiresAt) {
+			log.Printf("Challenge of %s claimed by %s expired\n", c.Address, c.Peer.Address)
+			pm.removeChallenge(nonce, c)
+		}
+	}
+}
+
+// removeChallenge forgets a challenge; the caller holds the mutex
+func (pm *PeerManager) removeChallenge(nonce string, c *challenge) {
+	delete(pm.Challenges, nonce)
+	if c.Peer.challenge == c {
+		c.Peer.challenge = nil
+	}
+}
+
+// handleChallenge answers a CHALLENGE with the nonce signed by the identity
+// key of this node, and completes the verification of an address with a
+// CHALLENGERESP. Both are handled here, before Authenticate, as their
+// sender is not verified yet.
+func (pm *PeerManager) handleChallenge(msg *message.Message) {
+	if err := msg.VerifySignature(); err != nil {
+		log.Printf("Dropping %s message from %s: %v\n", msg.Type, msg.Origin, err)
+		pm.Misbehaving(msg.Origin)
+		return
+	}
+
+	// Answer over the connection the challenge arrived on: dialing its
+	// sender would let anyone make this node connect to a third party
+	if msg.Type == message.CHALLENGE {
+		pm.Mutex.RLock()
+		peer, ok := pm.Peers[msg.Origin]
+		pm.Mutex.RUnlock()
+		if !ok {
+			return
+		}
+
+		response := message.NewMessage(message.CHALLENGERESP, pm.Address, msg.Sender, msg.Payload)
+		if err := peer.Send(response); err != nil {
+			log.Printf("Failed to answer the challenge of %s: %v\n", msg.Sender, err)
+		}
+		return
+	}
+
+	pm.Mutex.Lock()
+	c, ok := pm.Challenges[msg.Payload]
+	if !ok || time.Now().After(c.ExpiresAt) {
+		pm.Mutex.Unlock()
+		return // Unknown or expired challenge
+	}
+	pm.removeChallenge(msg.Payload, c)
+
+	// The address must answer with the key the peer claimed
+	if msg.Sender != c.Address || msg.PublicKey != c.PublicKey {
+		pm.Mutex.Unlock()
+		log.Printf("Inbound peer %s failed to prove it is %s\n", c.Peer.Address, c.Address)
+		pm.Misbehaving(c.Peer.GetAddress())
+		return
+	}
+	if err := pm.bindIdentity(c.Address, c.PublicKey); err != nil {
+		pm.Mutex.Unlock()
+		log.Printf("Inbound peer %s failed to prove it is %s: %v\n", c.Peer.Address, c.Address, err)
+		pm.Misbehaving(c.Peer.GetAddress())
+		return
+	}
+	pm.rekeyPeer(c.Peer, c.Address)
+	origin := c.Peer.GetAddress()
+	pm.Mutex.Unlock()
+
+	// Deliver the held messages again
+	log.Printf("Verified inbound peer %s\n", c.Address)
+	for _, held := range c.Messages {
+		held.Origin = origin
+		pm.MessageChannel <- held
+	}
+}
+
+// sameIP reports whether two addresses (IP:Port) have the same IP
+func sameIP(address1, address2 string) bool {
+	host1, _, err1 := net.SplitHostPort(address1)
+	host2, _, err2 := net.SplitHostPort(address2)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	ip1, ip2 := net.ParseIP(host1), net.ParseIP(host2)
+	return ip1 != nil && ip1.Equal(ip2)
+}
+
+// newChallengeNonce returns a random hex-encoded nonce
+func newChallengeNonce() (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate challenge nonce: %v", err)
+	}
+	return hex.EncodeToString(nonce), nil
+}
+
+// Misbehaving counts a misbehavior against the peer with the given address
+// and disconnects it once it reaches MAXMISBEHAVIOR
+func (pm *PeerManager) Misbehaving(address string) {
+	pm.Mutex.RLock()
+	peer, ok := pm.Peers[address]
+	pm.Mutex.RUnlock()
+	if !ok {
+		return
+	}
+
+	if peer.Misbehavior.Add(1) >= MAXMISBEHAVIOR {
+		log.Printf("Disconnecting misbehaving peer %s\n", address)
+		go peer.Close()
+	}
+}
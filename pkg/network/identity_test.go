@@ -0,0 +1,193 @@
+package network_test
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/message"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/network"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/wallet"
+)
+
+var testMagic = [4]byte{0xfa, 0xbf, 0xb5, 0xda}
+
+// newTestPeerManager creates a peer manager listening on a loopback port,
+// signing with a new key, and returns it with its listening address
+func newTestPeerManager(t *testing.T) (*network.PeerManager, *wallet.Wallet, string) {
+	t.Helper()
+
+	pm := network.NewPeerManager(testMagic, network.MAXINBOUND, network.MAXOUTBOUND)
+	if err := pm.Listen("0"); err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	port := pm.Listener.Addr().(*net.TCPAddr).Port
+	address := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+
+	signer := wallet.NewWallet()
+	pm.SetIdentity(address, signer)
+	go pm.Run()
+	t.Cleanup(pm.Close)
+	return pm, signer, address
+}
+
+// receive authenticates the messages received by the peer manager until one
+// passes, returning it and the errors of the others
+func receive(t *testing.T, pm *network.PeerManager, timeout time.Duration) (*message.Message, []error) {
+	t.Helper()
+
+	var errs []error
+	deadline := time.After(timeout)
+	for {
+		select {
+		case msg := <-pm.MessageChannel:
+			if err := pm.Authenticate(msg); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			return msg, errs
+		case <-deadline:
+			return nil, errs
+		}
+	}
+}
+
+// findPeer returns the peer keyed by the address, or nil
+func findPeer(pm *network.PeerManager, address string) *network.Peer {
+	for _, peer := range pm.GetPeers() {
+		if peer.GetAddress() == address {
+			return peer
+		}
+	}
+	return nil
+}
+
+func TestInboundPeerVerification(t *testing.T) {
+	a, _, aAddress := newTestPeerManager(t)
+	b, _, bAddress := newTestPeerManager(t)
+
+	// Read the peers concurrently with the re-keying, as the RPC server does
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			for _, peer := range a.GetPeers() {
+				_ = peer.Address
+				_ = peer.GetAddress()
+				peer.Send(message.NewMessage(message.HEARTBEAT, aAddress, peer.GetAddress(), ""))
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	// The message of the inbound peer is held until its address answers the challenge
+	if err := b.Send(aAddress, message.NewMessage(message.HEARTBEAT, bAddress, aAddress, "hello")); err != nil {
+		t.Fatalf("failed to send: %v", err)
+	}
+	msg, errs := receive(t, a, 5*time.Second)
+	if msg == nil {
+		t.Fatalf("message of the inbound peer was not delivered: %v", errs)
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], network.ErrUnverifiedSender) {
+		t.Errorf("errors before verification = %v, want one ErrUnverifiedSender", errs)
+	}
+	inbound := findPeer(a, msg.Origin)
+	if msg.Payload != "hello" || inbound == nil || !inbound.Inbound {
+		t.Fatalf("delivered payload %q from %s, want %q from the inbound peer", msg.Payload, msg.Origin, "hello")
+	}
+
+	// The challenge connection to the listening address carries the replies
+	if peer := findPeer(a, bAddress); peer == nil || peer.Inbound {
+		t.Fatalf("no outbound peer to the verified address %s", bAddress)
+	}
+
+	// Once it is gone, the inbound peer is re-keyed by its listening address,
+	// keeping the remote address of its connection
+	a.RemovePeer(bAddress)
+	if err := b.Send(aAddress, message.NewMessage(message.HEARTBEAT, bAddress, aAddress, "again")); err != nil {
+		t.Fatalf("failed to send: %v", err)
+	}
+	msg, errs = receive(t, a, 5*time.Second)
+	if msg == nil || len(errs) != 0 {
+		t.Fatalf("message of the verified peer = %v, errors %v", msg, errs)
+	}
+	if got, err := a.GetPeer(bAddress); err != nil || got != inbound {
+		t.Errorf("GetPeer(%s) = %v, %v, want the verified inbound peer", bAddress, got, err)
+	}
+	if inbound.Address == bAddress {
+		t.Errorf("address of the inbound peer changed to %s", inbound.Address)
+	}
+}
+
+// inboundMisbehavior sums the misbehavior of the inbound peers
+func inboundMisbehavior(pm *network.PeerManager) int64 {
+	misbehavior := int64(0)
+	for _, peer := range pm.GetPeers() {
+		if peer.Inbound {
+			misbehavior += peer.Misbehavior.Load()
+		}
+	}
+	return misbehavior
+}
+
+func TestInboundPeerClaimingAnotherAddress(t *testing.T) {
+	a, _, aAddress := newTestPeerManager(t)
+	b, bSigner, _ := newTestPeerManager(t)
+	_, _, cAddress := newTestPeerManager(t)
+
+	// The peer claims the address of another node, signing with its own key
+	b.SetIdentity(cAddress, bSigner)
+	for i := 0; i <= network.MAXCHALLENGES; i++ {
+		if err := b.Send(aAddress, message.NewMessage(message.HEARTBEAT, cAddress, aAddress, "spoofed")); err != nil {
+			t.Fatalf("failed to send: %v", err)
+		}
+
+		// The other node answers the challenge with its own key, until the
+		// peer runs out of challenges
+		msg, errs := receive(t, a, 500*time.Millisecond)
+		if msg != nil {
+			t.Fatalf("message claiming %s was delivered", cAddress)
+		}
+		if len(errs) != 1 {
+			t.Fatalf("errors = %v, want one", errs)
+		}
+		if limited := i == network.MAXCHALLENGES; errors.Is(errs[0], network.ErrUnverifiedSender) == limited {
+			t.Fatalf("error of message %d = %v, want a challenge: %v", i, errs[0], !limited)
+		}
+		if misbehavior := inboundMisbehavior(a); misbehavior != int64(min(i+1, network.MAXCHALLENGES)) {
+			t.Errorf("misbehavior after message %d = %d, want each failed challenge counted", i, misbehavior)
+		}
+	}
+	if peer := findPeer(a, cAddress); peer != nil && peer.Inbound {
+		t.Errorf("inbound peer was keyed by the claimed address %s", cAddress)
+	}
+}
+
+func TestInboundPeerClaimingAnotherIP(t *testing.T) {
+	a, _, aAddress := newTestPeerManager(t)
+	b, bSigner, _ := newTestPeerManager(t)
+
+	// The claimed address is not dialed
+	claimed := "192.0.2.1:8333"
+	b.SetIdentity(claimed, bSigner)
+	if err := b.Send(aAddress, message.NewMessage(message.HEARTBEAT, claimed, aAddress, "reflected")); err != nil {
+		t.Fatalf("failed to send: %v", err)
+	}
+	msg, errs := receive(t, a, 500*time.Millisecond)
+	if msg != nil {
+		t.Fatalf("message claiming %s was delivered", claimed)
+	}
+	if len(errs) != 1 || errors.Is(errs[0], network.ErrUnverifiedSender) {
+		t.Errorf("errors = %v, want one rejection without a challenge", errs)
+	}
+	if peer := findPeer(a, claimed); peer != nil {
+		t.Errorf("peer manager connected to the claimed address %s", claimed)
+	}
+}
@@ -0,0 +1,234 @@
+package network
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/message"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/utils"
+)
+
+const (
+	SENDQUEUESIZE        = 256              // Maximum number of queued outgoing messages per peer
+	WRITETIMEOUT         = 10 * time.Second // Timeout for writing a frame
+	DIALTIMEOUT          = 5 * time.Second  // Timeout for establishing a connection
+	MAXRECONNECTATTEMPTS = 5                // Reconnection attempts before an outbound peer is dropped
+	MAXBACKOFF           = 60 * time.Second // Upper bound of the reconnection backoff
+	CLOSETIMEOUT         = 2 * time.Second  // Time allowed to flush the send queue on close
+)
+
+type Peer struct {
+	Address          string                 // Dialed address of an outbound peer, or remote address of an inbound peer
+	Inbound          bool                   // Whether the peer connected to us
+	ConnectedAt      int64                  // Unix time the peer was added
+	MessagesSent     atomic.Int64           // Number of messages written to the peer
+	MessagesReceived atomic.Int64           // Number of messages read from the peer
+	Misbehavior      atomic.Int64           // Number of messages that failed authentication
+	SendQueue        chan *message.Message  // Bounded queue of outgoing messages
+	manager          *PeerManager           // Owning peer manager
+	conn             net.Conn               // Current connection (nil while reconnecting)
+	quit             chan struct{}          // Closed when the peer is shut down
+	done             chan struct{}          // Closed when the peer goroutine has exited
+	closeOnce        *sync.Once             // Guards the shutdown
+	challenge        *challenge             // Pending verification of the address claimed by the inbound peer
+	challenges       int                    // Number of challenges the inbound peer triggered
+	listenAddress    atomic.Pointer[string] // Verified listening address of the inbound peer, keying it once set
+}
+
+// newPeer creates a peer; conn is nil for outbound peers that still have to dial
+func newPeer(address string, inbound bool, conn net.Conn, manager *PeerManager) *Peer {
+	return &Peer{
+		Address:     address,
+		Inbound:     inbound,
+		ConnectedAt: utils.GetCurrentTimeInUnix(),
+		SendQueue:   make(chan *message.Message, SENDQUEUESIZE),
+		manager:     manager,
+		conn:        conn,
+		quit:        make(chan struct{}),
+		done:        make(chan struct{}),
+		closeOnce:   &sync.Once{},
+	}
+}
+
+// GetAddress returns the address the peer is keyed by: its verified
+// listening address once an inbound peer proved it, or else Address
+func (p *Peer) GetAddress() string {
+	if address := p.listenAddress.Load(); address != nil {
+		return *address
+	}
+	return p.Address
+}
+
+// Send queues a message for the peer without blocking
+func (p *Peer) Send(msg *message.Message) error {
+	select {
+	case <-p.quit:
+		return fmt.Errorf("peer %s is closed", p.Address)
+	default:
+	}
+
+	select {
+	case p.SendQueue <- msg:
+		return nil
+	default:
+		return fmt.Errorf("send queue of peer %s is full", p.Address)
+	}
+}
+
+// run maintains the connection to the peer. Outbound peers are redialed
+// with exponential backoff; inbound peers are dropped when they disconnect.
+func (p *Peer) run() {
+	defer close(p.done)
+	defer p.manager.removePeer(p)
+
+	attempt := 0
+	for {
+		// Dial the outbound peer
+		if p.conn == nil {
+			if p.Inbound {
+				return
+			}
+
+			conn, err := net.DialTimeout("tcp", p.Address, DIALTIMEOUT)
+			if err != nil {
+				attempt++
+				if attempt > MAXRECONNECTATTEMPTS {
+					log.Printf("Giving up on peer %s: %v\n", p.Address, err)
+					return
+				}
+				if !p.sleep(backoff(attempt)) {
+					return
+				}
+				continue
+			}
+			attempt = 0
+			p.conn = conn
+		}
+
+		// Read and write until the connection breaks or the peer is closed
+		connClosed := make(chan struct{})
+		go p.readLoop(p.conn, connClosed)
+		err := p.writeLoop(p.conn, connClosed)
+		p.conn.Close()
+		p.conn = nil
+
+		if err == nil {
+			return
+		}
+		log.Printf("Connection to peer %s lost: %v\n", p.Address, err)
+	}
+}
+
+// readLoop reads frames from the connection and hands the messages to the manager
+func (p *Peer) readLoop(conn net.Conn, connClosed chan struct{}) {
+	defer close(connClosed)
+
+	reader := bufio.NewReader(conn)
+	for {
+		// Read a complete frame
+		payload, err := ReadFrame(reader, p.manager.Magic)
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("Failed to read from peer %s: %v\n", p.Address, err)
+			}
+			return
+		}
+
+		// Deserialize the message
+		msg, err := message.DeserializeMessage(string(payload))
+		if err != nil {
+			log.Printf("Failed to deserialize message: %v\n", err)
+			continue
+		}
+		p.MessagesReceived.Add(1)
+		log.Printf("Received %s message from %s\n", msg.Type, msg.Sender)
+
+		// Hand the message to the manager
+		p.manager.deliver(p, msg)
+	}
+}
+
+// writeLoop writes queued messages to the connection. It returns nil once
+// the peer is closed and the queue is flushed, or an error if the connection broke.
+func (p *Peer) writeLoop(conn net.Conn, connClosed chan struct{}) error {
+	for {
+		select {
+		case msg := <-p.SendQueue:
+			if err := p.writeMessage(conn, msg); err != nil {
+				return err
+			}
+		case <-connClosed:
+			return fmt.Errorf("connection closed by peer")
+		case <-p.quit:
+			// Flush the remaining messages before closing
+			for {
+				select {
+				case msg := <-p.SendQueue:
+					if err := p.writeMessage(conn, msg); err != nil {
+						return nil
+					}
+				default:
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// writeMessage signs and serializes a message and writes it as a frame
+func (p *Peer) writeMessage(conn net.Conn, msg *message.Message) error {
+	msg, err := p.manager.signMessage(msg)
+	if err != nil {
+		log.Printf("%v\n", err)
+		return nil
+	}
+
+	msgData, err := msg.Serialize()
+	if err != nil {
+		log.Printf("failed to serialize message: %v\n", err)
+		return nil
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(WRITETIMEOUT))
+	if err := WriteFrame(conn, p.manager.Magic, []byte(msgData)); err != nil {
+		return err
+	}
+
+	p.MessagesSent.Add(1)
+	log.Printf("Sent %s message to %s\n", msg.Type, p.Address)
+	return nil
+}
+
+// sleep waits for the given duration and reports false if the peer was closed meanwhile
+func (p *Peer) sleep(d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-p.quit:
+		return false
+	}
+}
+
+// Close shuts the peer down after flushing its send queue
+func (p *Peer) Close() {
+	p.closeOnce.Do(func() {
+		close(p.quit)
+	})
+
+	select {
+	case <-p.done:
+	case <-time.After(CLOSETIMEOUT):
+	}
+}
+
+// backoff returns the reconnection delay for the given attempt
+func backoff(attempt int) time.Duration {
+	delay := time.Second << (attempt - 1)
+	return min(delay, MAXBACKOFF)
+}
@@ -0,0 +1,194 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/message"
+)
+
+const (
+	MAXINBOUND        = 32   // Default limit of inbound peers
+	MAXOUTBOUND       = 16   // Default limit of outbound peers
+	MESSAGECHANNELCAP = 1024 // Capacity of the channel of received messages
+)
+
+type PeerManager struct {
+	Magic          [4]byte               // Network magic starting every frame
+	Listener       net.Listener          // Listener to accept incoming connections (nil for clients)
+	Address        string                // Listening address of this node, sent as the sender of every message
+	Signer         Signer                // Identity key signing every outgoing message
+	Peers          map[string]*Peer      // Peer.GetAddress() -> Peer
+	Identities     map[string]string     // Address -> Public key the address is bound to
+	Challenges     map[string]*challenge // Nonce -> Pending verification of an address
+	MaxInbound     int                   // Maximum number of inbound peers
+	MaxOutbound    int                   // Maximum number of outbound peers
+	MessageChannel chan *message.Message // Channel of messages received from all peers
+	Mutex          *sync.RWMutex         // Mutex to protect the peers and identities
+}
+
+// NewPeerManager creates a new peer manager speaking the network with the given magic
+func NewPeerManager(magic [4]byte, maxInbound, maxOutbound int) *PeerManager {
+	return &PeerManager{
+		Magic:          magic,
+		Peers:          make(map[string]*Peer),
+		Identities:     make(map[string]string),
+		Challenges:     make(map[string]*challenge),
+		MaxInbound:     maxInbound,
+		MaxOutbound:    maxOutbound,
+		MessageChannel: make(chan *message.Message, MESSAGECHANNELCAP),
+		Mutex:          &sync.RWMutex{},
+	}
+}
+
+// Listen starts listening on the specified port
+func (pm *PeerManager) Listen(port string) error {
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		return fmt.Errorf("failed to start server on port %s: %v", port, err)
+	}
+
+	pm.Listener = listener
+	return nil
+}
+
+// Run accepts inbound connections until the listener is closed
+func (pm *PeerManager) Run() {
+	for {
+		conn, err := pm.Listener.Accept()
+		if err != nil {
+			// Stop once the listener has been closed
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("failed to accept connection: %v\n", err)
+			continue
+		}
+
+		// Enforce the inbound limit
+		if pm.countPeers(true) >= pm.MaxInbound {
+			log.Printf("Rejecting connection from %s: too many inbound peers\n", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+
+		// Key the peer by its remote address until it authenticates
+		peer := newPeer(conn.RemoteAddr().String(), true, conn, pm)
+		pm.Mutex.Lock()
+		pm.Peers[peer.Address] = peer
+		pm.Mutex.Unlock()
+
+		go peer.run()
+	}
+}
+
+// GetPeer returns the peer with the given address, connecting to it if needed
+func (pm *PeerManager) GetPeer(address string) (*Peer, error) {
+	pm.Mutex.Lock()
+	defer pm.Mutex.Unlock()
+
+	if peer, ok := pm.Peers[address]; ok {
+		return peer, nil
+	}
+
+	// Enforce the outbound limit
+	if pm.countPeersLocked(false) >= pm.MaxOutbound {
+		return nil, fmt.Errorf("cannot connect to %s: too many outbound peers", address)
+	}
+
+	peer := newPeer(address, false, nil, pm)
+	pm.Peers[address] = peer
+	go peer.run()
+
+	return peer, nil
+}
+
+// Send sends a message to the peer with the given address
+func (pm *PeerManager) Send(address string, msg *message.Message) error {
+	peer, err := pm.GetPeer(address)
+	if err != nil {
+		return err
+	}
+	return peer.Send(msg)
+}
+
+// GetPeers returns a snapshot of the connected peers
+func (pm *PeerManager) GetPeers() []*Peer {
+	pm.Mutex.RLock()
+	defer pm.Mutex.RUnlock()
+
+	peers := make([]*Peer, 0, len(pm.Peers))
+	for _, peer := range pm.Peers {
+		peers = append(peers, peer)
+	}
+	return peers
+}
+
+// RemovePeer disconnects and forgets the peer with the given address
+func (pm *PeerManager) RemovePeer(address string) {
+	pm.Mutex.RLock()
+	peer, ok := pm.Peers[address]
+	pm.Mutex.RUnlock()
+
+	if ok {
+		peer.Close()
+	}
+}
+
+// deliver passes a message read from a peer to the message channel, tagged
+// with the address of the connection it arrived on
+func (pm *PeerManager) deliver(peer *Peer, msg *message.Message) {
+	msg.Origin = peer.GetAddress()
+
+	// Challenges verify the sender, so they are handled before Authenticate
+	if msg.Type == message.CHALLENGE || msg.Type == message.CHALLENGERESP {
+		pm.handleChallenge(msg)
+		return
+	}
+
+	pm.MessageChannel <- msg
+}
+
+// removePeer forgets a peer whose goroutine has exited
+func (pm *PeerManager) removePeer(peer *Peer) {
+	pm.Mutex.Lock()
+	defer pm.Mutex.Unlock()
+
+	key := peer.GetAddress()
+	if pm.Peers[key] == peer {
+		delete(pm.Peers, key)
+	}
+}
+
+// countPeers counts the inbound or outbound peers
+func (pm *PeerManager) countPeers(inbound bool) int {
+	pm.Mutex.RLock()
+	defer pm.Mutex.RUnlock()
+
+	return pm.countPeersLocked(inbound)
+}
+
+// countPeersLocked counts the inbound or outbound peers; the caller holds the mutex
+func (pm *PeerManager) countPeersLocked(inbound bool) int {
+	count := 0
+	for _, peer := range pm.Peers {
+		if peer.Inbound == inbound {
+			count++
+		}
+	}
+	return count
+}
+
+// Close closes the listener and all peers
+func (pm *PeerManager) Close() {
+	if pm.Listener != nil {
+		pm.Listener.Close()
+	}
+
+	for _, peer := range pm.GetPeers() {
+		peer.Close()
+	}
+}
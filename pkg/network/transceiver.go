@@ -1,55 +1,57 @@
 package network
 
-import "github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/message"
+import (
+	"log"
+
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/message"
+)
 
 type Transceiver struct {
-	ReceiverChannel    chan *message.Message // Channel to receive messages
-	TransmitterChannel chan *message.Message // Channel to transmit messages
-	Receiver           *Receiver             // Receiver instance
-	Transmitter        *Transmitter          // Transmitter instance
+	PeerManager *PeerManager // Peer manager holding the connections
 }
 
-// NewTranceiver creates a new tranceiver
-func NewTransceiver(port string) (*Transceiver, error) {
-	tc := &Transceiver{
-		ReceiverChannel:    make(chan *message.Message),
-		TransmitterChannel: make(chan *message.Message),
-	}
-
-	var err error
-	tc.Receiver, err = NewReceiver(port, tc.ReceiverChannel)
-	if err != nil {
+// NewTranceiver creates a new tranceiver for the network with the given magic,
+// signing its messages with the given identity
+func NewTransceiver(IPAddress, port string, magic [4]byte, identity Signer) (*Transceiver, error) {
+	peerManager := NewPeerManager(magic, MAXINBOUND, MAXOUTBOUND)
+	peerManager.SetIdentity(IPAddress, identity)
+	if err := peerManager.Listen(port); err != nil {
 		return nil, err
 	}
-	tc.Transmitter = NewTransmitter(tc.TransmitterChannel)
 
-	return tc, nil
+	return &Transceiver{
+		PeerManager: peerManager,
+	}, nil
 }
 
 // Run runs the tranceiver
 func (tc *Transceiver) Run() {
-	go tc.Receiver.Run()
-	go tc.Transmitter.Run()
+	go tc.PeerManager.Run()
+}
+
+// GetPeer returns the peer handle for an address, connecting to it if needed
+func (tc *Transceiver) GetPeer(address string) (*Peer, error) {
+	return tc.PeerManager.GetPeer(address)
 }
 
-// Transmit sends a message
+// Transmit sends a message to its receipient
 func (tc *Transceiver) Transmit(msg *message.Message) {
-	tc.Transmitter.Transmit(msg)
+	if err := tc.PeerManager.Send(msg.Receipient, msg); err != nil {
+		log.Printf("failed to send %s message: %v\n", msg.Type, err)
+	}
 }
 
 // Receive receives a message
 func (tc *Transceiver) Receive() (*message.Message, bool) {
-	return tc.Receiver.Receive()
+	select {
+	case msg := <-tc.PeerManager.MessageChannel:
+		return msg, true
+	default:
+		return nil, false // No message available
+	}
 }
 
 // Close closes the tranceiver
 func (tc *Transceiver) Close() {
-	// Close the receiver and transmitter
-	tc.Receiver.Close()
-	tc.Transmitter.Close()
-
-	// Close the channels
-	close(tc.ReceiverChannel)
-	close(tc.TransmitterChannel)
-
+	tc.PeerManager.Close()
 }
@@ -0,0 +1,25 @@
+package node
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/wallet"
+)
+
+const NODEKEYFILE = "nodekey.json" // Identity keypair of the node in the data directory
+
+// loadIdentity loads the identity keypair of the node, creating it on first start
+func loadIdentity(dataDir string) (*wallet.Wallet, error) {
+	filename := filepath.Join(dataDir, NODEKEYFILE)
+	if _, err := os.Stat(filename); err == nil {
+		return wallet.LoadFromFile(filename)
+	}
+
+	identity := wallet.NewWallet()
+	if err := identity.SaveToFile(filename); err != nil {
+		return nil, fmt.Errorf("failed to save node identity: %v", err)
+	}
+	return identity, nil
+}
@@ -1,13 +1,16 @@
 package node
 
 import (
+	"errors"
 	"log"
 
 	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain"
 	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/block"
 	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/transaction"
 	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/message"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/network"
 	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/p2p/membership"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/utils"
 )
 
 // HandleIncomingMessage processes incoming messages
@@ -18,6 +21,16 @@ func (node *Node) handleIncomingMessage() {
 			continue // Skip iteration if no message
 		}
 
+		// Drop messages that do not come from the identity they claim
+		if err := node.Transceiver.PeerManager.Authenticate(msg); err != nil {
+			if errors.Is(err, network.ErrUnverifiedSender) {
+				continue // Delivered again once the sender is verified
+			}
+			log.Printf("Dropping %s message from %s: %v\n", msg.Type, msg.Origin, err)
+			node.Transceiver.PeerManager.Misbehaving(msg.Origin)
+			continue
+		}
+
 		// Process the message based on its type
 		switch msg.Type {
 		case message.JOINREQ:
@@ -30,10 +43,16 @@ func (node *Node) handleIncomingMessage() {
 			node.handleNewTransactionMsg(msg)
 		case message.NEWBLOCK:
 			node.handleNewBlockMsg(msg)
-		case message.BLOCKCHAINREQ:
-			node.handleBlockChainRequest(msg)
-		case message.BLOCKCHAINRESP:
-			node.handleBlockchainResponse(msg)
+		case message.INV:
+			node.handleInvMsg(msg)
+		case message.GETHEADERS:
+			node.handleGetHeadersMsg(msg)
+		case message.HEADERS:
+			node.handleHeadersMsg(msg)
+		case message.GETBLOCKS:
+			node.handleGetBlocksMsg(msg)
+		case message.BLOCK:
+			node.handleBlockMsg(msg)
 		default:
 			log.Printf("Unknown message type: %s\n", msg.Type)
 		}
@@ -57,6 +76,9 @@ func (node *Node) handleJoinResponse(msg *message.Message) {
 
 	// Update the member list
 	node.MembershipManager.HandleJoinResponse(memberList)
+
+	// Sync the chain from the bootstrap node
+	node.SyncManager.RequestHeaders(msg.Sender)
 }
 
 // handleHeartbeatMsg handles a heartbeat message
@@ -70,6 +92,9 @@ func (node *Node) handleHeartbeatMsg(msg *message.Message) {
 
 	// Update the member list
 	node.MembershipManager.HandleHeartbeat(memberList)
+
+	// Heartbeats are sent directly, so their timestamp reflects the clock of the sender
+	node.Blockchain.TimeSource.AddTimeSample(msg.Sender, msg.Timestamp)
 }
 
 // handleNewTransactionMsg handles a new transaction message
@@ -88,11 +113,14 @@ func (node *Node) handleNewTransactionMsg(msg *message.Message) {
 		return
 	}
 
+	// Add the transaction to the pool
+	if err := node.Mempool.AddTransaction(tx); err != nil {
+		log.Printf("Rejected transaction: %v\n", err)
+		return
+	}
+
 	// Gossip the transaction
 	node.GossipManager.Gossip(msg)
-
-	// Add the transaction to the pool
-	node.Mempool.AddTransaction(tx)
 }
 
 // handleNewBlockMsg handles a new block message
@@ -104,42 +132,77 @@ func (node *Node) handleNewBlockMsg(msg *message.Message) {
 		return
 	}
 
-	if err := node.Blockchain.ValidateNewBlock(block); err != nil {
+	// Ignore the blocks already received
+	if node.Blockchain.HaveBlock(block.BlockID) {
+		return
+	}
+
+	_, err = node.Blockchain.ProcessBlock(block)
+	if err == blockchain.ErrOrphanBlock {
+		// Sync the missing blocks if the parent is unknown
+		node.SyncManager.RequestHeaders(msg.Sender)
+		return
+	}
+	if err != nil {
 		log.Printf("Invalid block: %s\n", err)
-		msg := message.NewMessage(message.BLOCKCHAINREQ, node.IPAddress, msg.Sender, "")
-		node.Transceiver.Transmit(msg)
-	} else {
-		node.GossipManager.Gossip(msg)
-		node.Blockchain.AddBlock(block)
-		node.Miner.StopPoW()
+		return
 	}
+
+	// Relay the valid block. The miner restarts on its own if it moved the tip.
+	node.GossipManager.Gossip(msg)
 }
 
-// handleBlockChainRequest handles a blockchain request message
-func (node *Node) handleBlockChainRequest(msg *message.Message) {
-	payload, err := node.Blockchain.Serialize()
+// handleInvMsg handles an INV message
+func (node *Node) handleInvMsg(msg *message.Message) {
+	blockIDs, err := utils.DeserializeHashes([]byte(msg.Payload))
 	if err != nil {
-		log.Printf("Failed to serialize blockchain: %v\n", err)
+		log.Printf("Failed to deserialize inventory: %v\n", err)
 		return
 	}
 
-	newMsg := message.NewMessage(message.BLOCKCHAINRESP, node.IPAddress, msg.Sender, payload)
-	node.Transceiver.Transmit(newMsg)
+	node.SyncManager.HandleInv(msg.Sender, blockIDs)
 }
 
-// handleBlockchainResponse handles a blockchain response message
-func (node *Node) handleBlockchainResponse(msg *message.Message) {
-	blockchain, err := blockchain.DeserializeBlockchain(msg.Payload)
+// handleGetHeadersMsg handles a GETHEADERS message
+func (node *Node) handleGetHeadersMsg(msg *message.Message) {
+	locator, err := utils.DeserializeHashes([]byte(msg.Payload))
 	if err != nil {
-		log.Printf("Failed to deserialize blockchain: %v\n", err)
+		log.Printf("Failed to deserialize block locator: %v\n", err)
 		return
 	}
 
-	if err := node.Blockchain.ShouldSwitchChain(blockchain); err != nil {
-		log.Printf("Invalid blockchain: %s\n", err)
-	} else {
-		log.Printf("Switching to a new blockchain\n")
-		node.Blockchain.SwitchChain(blockchain)
-		node.Miner.StopPoW()
+	node.SyncManager.HandleGetHeaders(msg.Sender, locator)
+}
+
+// handleHeadersMsg handles a HEADERS message
+func (node *Node) handleHeadersMsg(msg *message.Message) {
+	headers, err := block.DeserializeHeaders(msg.Payload)
+	if err != nil {
+		log.Printf("Failed to deserialize headers: %v\n", err)
+		return
+	}
+
+	node.SyncManager.HandleHeaders(msg.Sender, headers)
+}
+
+// handleGetBlocksMsg handles a GETBLOCKS message
+func (node *Node) handleGetBlocksMsg(msg *message.Message) {
+	blockIDs, err := utils.DeserializeHashes([]byte(msg.Payload))
+	if err != nil {
+		log.Printf("Failed to deserialize block IDs: %v\n", err)
+		return
 	}
+
+	node.SyncManager.HandleGetBlocks(msg.Sender, blockIDs)
+}
+
+// handleBlockMsg handles a BLOCK message
+func (node *Node) handleBlockMsg(msg *message.Message) {
+	b, err := block.DeserializeBlock(msg.Payload)
+	if err != nil {
+		log.Printf("Failed to deserialize block: %v\n", err)
+		return
+	}
+
+	node.SyncManager.HandleBlock(msg.Sender, b)
 }
@@ -2,11 +2,16 @@ package node
 
 import (
 	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/store"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/chaincfg"
 	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/mining"
 	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/mining/mempool"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/mining/stratum"
 	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/network"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/p2p/blocksync"
 	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/p2p/gossip"
 	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/p2p/membership"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/rpc"
 )
 
 type Node struct {
@@ -16,17 +21,48 @@ type Node struct {
 	Transceiver       *network.Transceiver          // Tranceiver instance
 	MembershipManager *membership.MembershipManager // Membership manager
 	GossipManager     *gossip.GossipManager         // Gossip manager
+	SyncManager       *blocksync.SyncManager        // Block sync manager
 	Mempool           *mempool.Mempool              // Mempool
 	Blockchain        *blockchain.Blockchain        // Blockchain
 	Miner             *mining.Miner                 // Miner
+	RPCServer         *rpc.Server                   // JSON-RPC server, or nil if disabled
+	StratumServer     *stratum.Server               // Stratum server for external miners, or nil if disabled
 }
 
-// NewNode creates a new P2P node
-func NewNode(IPAddress, port, address string) (*Node, error) {
-	var err error
+// NewNode creates a new P2P node on the network described by params; the RPC
+// and stratum servers are disabled if their address is empty
+func NewNode(params *chaincfg.Params, IPAddress, port, address, dataDir, rpcAddress, stratumAddress string) (_ *Node, err error) {
+	// Open the block store
+	blockStore, err := store.OpenBlockStore(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Release the store, the ports and the listeners opened so far if the
+	// node cannot be created
+	var transceiver *network.Transceiver
+	var stratumServer *stratum.Server
+	defer func() {
+		if err == nil {
+			return
+		}
+		if stratumServer != nil {
+			stratumServer.Close()
+		}
+		if transceiver != nil {
+			transceiver.Close()
+		}
+		blockStore.Close()
+	}()
+
+	// Load the identity of the node
+	identity, err := loadIdentity(dataDir)
+	if err != nil {
+		return nil, err
+	}
 
 	// Create a new tranceiver
-	transceiver, err := network.NewTransceiver(port)
+	transceiver, err = network.NewTransceiver(IPAddress, port, params.Magic, identity)
 	if err != nil {
 		return nil, err
 	}
@@ -37,15 +73,38 @@ func NewNode(IPAddress, port, address string) (*Node, error) {
 	// Create a Mempool
 	mempool := mempool.NewMempool()
 
-	// Create a Blockchain
-	blockchain := blockchain.NewBlockchain(mempool)
+	// Load the stored Blockchain
+	blockchain, err := blockchain.LoadBlockchain(params, mempool, blockStore)
+	if err != nil {
+		return nil, err
+	}
 
 	// Create a Gossip Manager
 	gossipManager := gossip.NewGossipManager(IPAddress, transceiver, membershipManager)
 
+	// Create a Sync Manager
+	syncManager := blocksync.NewSyncManager(IPAddress, transceiver, membershipManager, blockchain)
+
 	// Create a Miner
 	miner := mining.NewMiner(address, blockchain, gossipManager, mempool)
 
+	// Create a stratum server, failing if its port is taken
+	if stratumAddress != "" {
+		stratumServer = stratum.NewServer(stratumAddress, blockchain, mempool, miner)
+		if err := stratumServer.Listen(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Create a JSON-RPC server, failing if its port is taken
+	var rpcServer *rpc.Server
+	if rpcAddress != "" {
+		rpcServer = rpc.NewServer(rpcAddress, IPAddress, blockchain, mempool, membershipManager, gossipManager, miner, stratumServer)
+		if err := rpcServer.Listen(); err != nil {
+			return nil, err
+		}
+	}
+
 	return &Node{
 		IPAddress:         IPAddress,
 		Port:              port,
@@ -53,9 +112,12 @@ func NewNode(IPAddress, port, address string) (*Node, error) {
 		Transceiver:       transceiver,
 		MembershipManager: membershipManager,
 		GossipManager:     gossipManager,
+		SyncManager:       syncManager,
 		Mempool:           mempool,
 		Blockchain:        blockchain,
 		Miner:             miner,
+		RPCServer:         rpcServer,
+		StratumServer:     stratumServer,
 	}, nil
 }
 
@@ -73,17 +135,40 @@ func (node *Node) Run(bootstrapNodeAddr string) error {
 	// Run the gossip manager
 	go node.GossipManager.Run(60)
 
+	// Run the sync manager
+	go node.SyncManager.Run()
+
 	// Run the miner
 	go node.Miner.Run()
 
 	// Run the blockchain
 	go node.Blockchain.Run()
 
+	// Run the RPC server
+	if node.RPCServer != nil {
+		go node.RPCServer.Run()
+	}
+
+	// Run the stratum server
+	if node.StratumServer != nil {
+		go node.StratumServer.Run()
+	}
+
 	return nil
 }
 
 // Close closes the P2P node
 func (node *Node) Close() {
+	// Close the RPC server
+	if node.RPCServer != nil {
+		node.RPCServer.Close()
+	}
+
+	// Close the stratum server
+	if node.StratumServer != nil {
+		node.StratumServer.Close()
+	}
+
 	// Close the tranceiver
 	node.Transceiver.Close()
 
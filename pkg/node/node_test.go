@@ -0,0 +1,52 @@
+package node_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/chaincfg"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/node"
+)
+
+// listenLoopback listens on a free loopback port
+func listenLoopback(t *testing.T) net.Listener {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	return listener
+}
+
+// freePort returns a loopback port nothing listens on
+func freePort(t *testing.T) string {
+	t.Helper()
+
+	listener := listenLoopback(t)
+	defer listener.Close()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	return port
+}
+
+func TestNewNodeReleasesResourcesOnError(t *testing.T) {
+	dataDir := t.TempDir()
+	port := freePort(t)
+	IPAddress := net.JoinHostPort("127.0.0.1", port)
+	stratumAddress := net.JoinHostPort("127.0.0.1", freePort(t))
+
+	// The RPC port is taken once the P2P and stratum ports are bound
+	rpcListener := listenLoopback(t)
+	rpcAddress := rpcListener.Addr().String()
+	if _, err := node.NewNode(&chaincfg.RegTestParams, IPAddress, port, "", dataDir, rpcAddress, stratumAddress); err == nil {
+		t.Fatalf("NewNode() succeeded with the RPC port %s taken", rpcAddress)
+	}
+	rpcListener.Close()
+
+	// The failed node released its ports
+	n, err := node.NewNode(&chaincfg.RegTestParams, IPAddress, port, "", dataDir, rpcAddress, stratumAddress)
+	if err != nil {
+		t.Fatalf("NewNode() after a failed start error = %v", err)
+	}
+	n.Close()
+}
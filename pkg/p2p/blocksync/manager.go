@@ -0,0 +1,313 @@
+package blocksync
+
+import (
+	"fmt"
+	"log"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/block"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/message"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/network"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/p2p/membership"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/utils"
+)
+
+const (
+	MAXHEADERS   = 2000 // Maximum number of headers in a HEADERS message
+	MAXGETBLOCKS = 128  // Maximum number of blocks requested at a time, which fits in the send queue of a peer
+	TIMEANNOUNCE = 60   // Seconds between two tip announcements
+	TIMESYNC     = 120  // Seconds after which an unfinished sync is abandoned
+)
+
+type SyncState struct {
+	Peer      string          // Peer the chain is downloaded from
+	BaseWork  *big.Int        // Cumulative work of the known block the headers attach to
+	Headers   []*block.Header // Headers of the blocks still to be downloaded, in order
+	Requested int             // Number of leading headers whose blocks were requested
+	StartedAt int64           // Unix time the sync started
+}
+
+type SyncManager struct {
+	IPAddress         string                        // IP address of the node
+	Transceiver       *network.Transceiver          // Tranceiver instance
+	MembershipManager *membership.MembershipManager // Membership manager
+	Blockchain        *blockchain.Blockchain        // Blockchain reference
+	State             *SyncState                    // Ongoing sync, or nil
+	Mutex             *sync.Mutex                   // Mutex to protect the sync state
+}
+
+// NewSyncManager creates a new sync manager
+func NewSyncManager(
+	IPAddress string,
+	transceiver *network.Transceiver,
+	membershipManager *membership.MembershipManager,
+	blockchain *blockchain.Blockchain,
+) *SyncManager {
+	return &SyncManager{
+		IPAddress:         IPAddress,
+		Transceiver:       transceiver,
+		MembershipManager: membershipManager,
+		Blockchain:        blockchain,
+		Mutex:             &sync.Mutex{},
+	}
+}
+
+// Run periodically announces the tip to a random member so that lagging
+// peers notice they are behind
+func (mgr *SyncManager) Run() {
+	for {
+		time.Sleep(TIMEANNOUNCE * time.Second)
+
+		for _, member := range mgr.MembershipManager.SelectNMembers(1) {
+			mgr.AnnounceTip(member.IPAddress)
+		}
+	}
+}
+
+// AnnounceTip sends an INV message with the tip of the main chain to a peer
+func (mgr *SyncManager) AnnounceTip(peer string) {
+	tip, _ := mgr.Blockchain.GetTip()
+	mgr.sendHashes(message.INV, peer, []string{tip.BlockID})
+}
+
+// RequestHeaders sends a GETHEADERS message with a block locator to a peer
+func (mgr *SyncManager) RequestHeaders(peer string) {
+	mgr.sendHashes(message.GETHEADERS, peer, mgr.Blockchain.GetBlockLocator())
+}
+
+// HandleInv processes an INV message
+func (mgr *SyncManager) HandleInv(peer string, blockIDs []string) {
+	for _, blockID := range blockIDs {
+		if !mgr.Blockchain.HaveBlock(blockID) {
+			mgr.RequestHeaders(peer)
+			return
+		}
+	}
+}
+
+// HandleGetHeaders processes a GETHEADERS message
+func (mgr *SyncManager) HandleGetHeaders(peer string, locator []string) {
+	// Find the last block shared with the requester
+	forkHeight := mgr.Blockchain.FindForkPoint(locator)
+
+	// Send the headers following the fork point
+	headers := mgr.Blockchain.GetHeadersAfter(forkHeight, MAXHEADERS)
+	payload, err := block.SerializeHeaders(headers)
+	if err != nil {
+		log.Printf("Failed to serialize headers: %v\n", err)
+		return
+	}
+
+	msg := message.NewMessage(message.HEADERS, mgr.IPAddress, peer, payload)
+	mgr.Transceiver.Transmit(msg)
+}
+
+// HandleGetBlocks processes a GETBLOCKS message
+func (mgr *SyncManager) HandleGetBlocks(peer string, blockIDs []string) {
+	if len(blockIDs) > MAXGETBLOCKS {
+		blockIDs = blockIDs[:MAXGETBLOCKS]
+	}
+
+	for _, blockID := range blockIDs {
+		b := mgr.Blockchain.GetKnownBlock(blockID)
+		if b == nil {
+			continue
+		}
+
+		msg := block.NewBlockMessage(b, mgr.IPAddress, peer)
+		if msg == nil {
+			log.Printf("Failed to serialize block %s\n", blockID)
+			continue
+		}
+		mgr.Transceiver.Transmit(msg)
+	}
+}
+
+// HandleHeaders processes a HEADERS message
+func (mgr *SyncManager) HandleHeaders(peer string, headers []*block.Header) {
+	mgr.Mutex.Lock()
+	defer mgr.Mutex.Unlock()
+
+	if len(headers) == 0 {
+		return
+	}
+
+	// Only sync with one peer at a time
+	if mgr.State != nil && mgr.State.Peer != peer && !mgr.isStale() {
+		return
+	}
+
+	// Validate the headers and find where they attach
+	state, err := mgr.attachHeaders(peer, headers)
+	if err != nil {
+		log.Printf("Invalid headers from %s: %v\n", peer, err)
+		mgr.State = nil
+		return
+	}
+	mgr.State = state
+
+	// Keep downloading headers until the peer has no more
+	if len(headers) == MAXHEADERS {
+		mgr.sendHashes(message.GETHEADERS, peer, []string{headers[len(headers)-1].BlockID})
+		return
+	}
+
+	// Drop the headers if the peer's chain does not have more work
+	if !mgr.hasMoreWork(state) {
+		mgr.State = nil
+		return
+	}
+
+	// Download the missing blocks
+	log.Printf("Downloading %d blocks from %s\n", len(state.Headers), peer)
+	mgr.requestBlocks(state)
+}
+
+// requestBlocks requests the next blocks of the sync once less than half of
+// MAXGETBLOCKS are still in flight, so that the blocks sent back never
+// overflow the send queue of the peer
+func (mgr *SyncManager) requestBlocks(state *SyncState) {
+	if state.Requested >= MAXGETBLOCKS/2 {
+		return
+	}
+
+	end := min(len(state.Headers), state.Requested+MAXGETBLOCKS)
+	if end == state.Requested {
+		return
+	}
+	blockIDs := make([]string, 0, end-state.Requested)
+	for _, header := range state.Headers[state.Requested:end] {
+		blockIDs = append(blockIDs, header.BlockID)
+	}
+	state.Requested = end
+	mgr.sendHashes(message.GETBLOCKS, state.Peer, blockIDs)
+}
+
+// HandleBlock processes a BLOCK message and reports whether the tip changed.
+// Blocks of any branch are handed to the block index, which holds them until
+// their parent arrives and switches to the branch with the most work.
+func (mgr *SyncManager) HandleBlock(peer string, b *block.Block) bool {
+	mgr.Mutex.Lock()
+	defer mgr.Mutex.Unlock()
+
+	// A block already received from another peer or through gossip still
+	// counts as progress
+	tipChanged, err := mgr.Blockchain.ProcessBlock(b)
+	if err == blockchain.ErrOrphanBlock || err == blockchain.ErrKnownBlock {
+		err = nil
+	}
+	if err != nil {
+		log.Printf("Rejected block %s from %s: %v\n", b.BlockID, peer, err)
+	}
+
+	// Track the progress of the sync
+	state := mgr.State
+	if state == nil || state.Peer != peer || !state.removePending(b.BlockID) {
+		return tipChanged
+	}
+	if err != nil {
+		log.Printf("Sync with %s aborted\n", peer)
+		mgr.State = nil
+	} else if len(state.Headers) == 0 {
+		mgr.State = nil
+	} else {
+		mgr.requestBlocks(state)
+	}
+
+	return tipChanged
+}
+
+// attachHeaders validates the headers and returns the sync state they extend
+func (mgr *SyncManager) attachHeaders(peer string, headers []*block.Header) (*SyncState, error) {
+	// Continue an ongoing sync with the same peer
+	state := mgr.State
+	if state != nil && state.Peer == peer && len(state.Headers) > 0 &&
+		headers[0].PrevHash == state.Headers[len(state.Headers)-1].BlockID {
+		if err := validateHeaderChain(headers, mgr.Blockchain.Params.PowLimitBits); err != nil {
+			return nil, err
+		}
+		state.Headers = append(state.Headers, headers...)
+		return state, nil
+	}
+
+	// Skip the headers of the blocks already known on any branch
+	for len(headers) > 0 && mgr.Blockchain.HaveBlock(headers[0].BlockID) {
+		headers = headers[1:]
+	}
+	if len(headers) == 0 {
+		return nil, fmt.Errorf("no new headers")
+	}
+
+	// Start a new sync from the known block the headers attach to
+	baseWork := mgr.Blockchain.GetBlockWork(headers[0].PrevHash)
+	if baseWork == nil {
+		return nil, fmt.Errorf("headers do not connect to a known block")
+	}
+	if err := validateHeaderChain(headers, mgr.Blockchain.Params.PowLimitBits); err != nil {
+		return nil, err
+	}
+
+	return &SyncState{
+		Peer:      peer,
+		BaseWork:  baseWork,
+		Headers:   headers,
+		StartedAt: utils.GetCurrentTimeInUnix(),
+	}, nil
+}
+
+// hasMoreWork checks if the chain described by the sync state has more work than the main chain
+func (mgr *SyncManager) hasMoreWork(state *SyncState) bool {
+	work := new(big.Int).Set(state.BaseWork)
+	for _, header := range state.Headers {
+		work.Add(work, block.CalcWork(header.Bits))
+	}
+	return work.Cmp(mgr.Blockchain.GetCumulativePoW()) > 0
+}
+
+// isStale checks if the ongoing sync has run for too long
+func (mgr *SyncManager) isStale() bool {
+	return utils.GetCurrentTimeInUnix()-mgr.State.StartedAt > TIMESYNC
+}
+
+// sendHashes sends a message whose payload is a list of hashes
+func (mgr *SyncManager) sendHashes(msgType, peer string, hashes []string) {
+	payload, err := utils.SerializeHashes(hashes)
+	if err != nil {
+		log.Printf("Failed to serialize hashes: %v\n", err)
+		return
+	}
+
+	msg := message.NewMessage(msgType, mgr.IPAddress, peer, string(payload))
+	mgr.Transceiver.Transmit(msg)
+}
+
+// removePending removes a downloaded block from the blocks still to be
+// downloaded and reports whether it was pending
+func (state *SyncState) removePending(blockID string) bool {
+	for i, header := range state.Headers {
+		if header.BlockID == blockID {
+			state.Headers = append(state.Headers[:i], state.Headers[i+1:]...)
+			if i < state.Requested {
+				state.Requested--
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// validateHeaderChain validates that the headers link to each other and carry valid PoW
+func validateHeaderChain(headers []*block.Header, powLimitBits uint32) error {
+	for i, header := range headers {
+		if err := header.Validate(powLimitBits); err != nil {
+			return fmt.Errorf("invalid header %s: %v", header.BlockID, err)
+		}
+		if i > 0 && header.PrevHash != headers[i-1].BlockID {
+			return fmt.Errorf("header %s does not link to the previous header", header.BlockID)
+		}
+	}
+	return nil
+}
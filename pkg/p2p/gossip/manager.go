@@ -54,8 +54,7 @@ func (mgr *GossipManager) Gossip(msg *message.Message) {
 
 	// Send the message to the selected members
 	for _, member := range selectedMembers {
-		msg.Receipient = member.IPAddress
-		mgr.Transceiver.Transmit(msg)
+		mgr.MembershipManager.SendToMember(member.IPAddress, msg)
 	}
 
 	// Mark the message as seen
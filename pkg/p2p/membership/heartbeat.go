@@ -48,7 +48,6 @@ func (mgr *MembershipManager) GossipHeartbeat() {
 	// Send HEARTBEAT message to some random members in the network
 	for _, member := range selectedMembers {
 		// Send HEARTBEAT message to the member
-		message.Receipient = member.IPAddress
-		mgr.Transceiver.Transmit(message)
+		mgr.SendToMember(member.IPAddress, message)
 	}
 }
@@ -19,7 +19,7 @@ func (mgr *MembershipManager) JoinGroup(bootstrapNodeAddress string) {
 	message := NewJOINREQMessage(mgr.IPAddress, bootstrapNodeAddress)
 
 	// Send JOINREQ message
-	mgr.Transceiver.Transmit(message)
+	mgr.SendToMember(bootstrapNodeAddress, message)
 }
 
 // IntroduceSelfToGroup sends a JOINREQ message to the bootstrap node
@@ -75,7 +75,7 @@ func (mgr *MembershipManager) HandleJoinRequest(requester string) {
 	message := NewJOINRESPMessage(mgr.IPAddress, requester, payload)
 
 	// Send JOINRESP message
-	mgr.Transceiver.Transmit(message)
+	mgr.SendToMember(requester, message)
 }
 
 // HandleJoinResponse processes a JOINRESP message
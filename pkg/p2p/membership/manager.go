@@ -1,8 +1,10 @@
 package membership
 
 import (
+	"log"
 	"time"
 
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/message"
 	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/network"
 	"golang.org/x/exp/rand"
 )
@@ -35,6 +37,22 @@ func (mgr *MembershipManager) Run(bootstrapNodeAddr string) {
 	}
 }
 
+// SendToMember sends a copy of the message to a member through its peer handle
+func (mgr *MembershipManager) SendToMember(address string, msg *message.Message) {
+	peer, err := mgr.Transceiver.GetPeer(address)
+	if err != nil {
+		log.Printf("Failed to reach member %s: %v\n", address, err)
+		return
+	}
+
+	// Copy the message since it is serialized asynchronously
+	msgCopy := *msg
+	msgCopy.Receipient = address
+	if err := peer.Send(&msgCopy); err != nil {
+		log.Printf("Failed to send %s message to %s: %v\n", msg.Type, address, err)
+	}
+}
+
 func (mgr *MembershipManager) GetNumberOfMembers() int {
 	mgr.MemberList.Mutex.RLock()
 	defer mgr.MemberList.Mutex.RUnlock()
@@ -0,0 +1,73 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const CLIENTTIMEOUT = 10 * time.Second
+
+type Client struct {
+	URL        string       // URL of the RPC server
+	httpClient *http.Client // Underlying HTTP client
+	nextID     atomic.Int64 // ID of the next request
+}
+
+// NewClient creates a new JSON-RPC client for the server at the given address
+func NewClient(address string) *Client {
+	return &Client{
+		URL:        "http://" + address,
+		httpClient: &http.Client{Timeout: CLIENTTIMEOUT},
+	}
+}
+
+// Call calls a method on the server and decodes its result into result
+func (c *Client) Call(method string, params []interface{}, result interface{}) error {
+	// Encode the parameters
+	rawParams := make([]json.RawMessage, len(params))
+	for i, param := range params {
+		data, err := json.Marshal(param)
+		if err != nil {
+			return fmt.Errorf("failed to encode parameter %d: %v", i, err)
+		}
+		rawParams[i] = data
+	}
+
+	id, _ := json.Marshal(c.nextID.Add(1))
+	body, err := json.Marshal(&Request{
+		JSONRPC: JSONRPCVERSION,
+		Method:  method,
+		Params:  rawParams,
+		ID:      id,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode request: %v", err)
+	}
+
+	// Send the request
+	resp, err := c.httpClient.Post(c.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to call %s: %v", method, err)
+	}
+	defer resp.Body.Close()
+
+	// Decode the response
+	var rpcResp struct {
+		Result json.RawMessage `json:"result"`
+		Error  *Error          `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&rpcResp); err != nil {
+		return fmt.Errorf("failed to decode response: %v", err)
+	}
+	if rpcResp.Error != nil {
+		return rpcResp.Error
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(rpcResp.Result, result)
+}
@@ -0,0 +1,317 @@
+package rpc
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/amount"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/block"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/transaction"
+)
+
+type BlockResult struct {
+	Height        int          `json:"height"`        // Height of the block in the main chain
+	Confirmations int          `json:"confirmations"` // Number of blocks on top of the block, including itself
+	Block         *block.Block `json:"block"`         // The block
+}
+
+type TransactionResult struct {
+	Transaction   *transaction.Transaction `json:"transaction"`        // The transaction
+	BlockID       string                   `json:"block_id,omitempty"` // ID of the block holding the transaction
+	Confirmations int                      `json:"confirmations"`      // Number of confirmations, 0 if pending
+}
+
+type PeerInfo struct {
+	Address          string `json:"address"`           // Address of the peer
+	Inbound          bool   `json:"inbound"`           // Whether the peer connected to us
+	ConnectedAt      int64  `json:"connected_at"`      // Unix time the peer was added
+	MessagesSent     int64  `json:"messages_sent"`     // Number of messages written to the peer
+	MessagesReceived int64  `json:"messages_received"` // Number of messages read from the peer
+	Misbehavior      int64  `json:"misbehavior"`       // Number of messages that failed authentication
+}
+
+type MemberInfo struct {
+	Address   string `json:"address"`   // Address of the member
+	Heartbeat int64  `json:"heartbeat"` // Number of heartbeats
+	Timestamp int64  `json:"timestamp"` // Timestamp of the last heartbeat
+}
+
+type PeerInfoResult struct {
+	Members []*MemberInfo `json:"members"` // Members of the group
+	Peers   []*PeerInfo   `json:"peers"`   // Open peer connections
+}
+
+type MiningInfo struct {
+	Network              string        `json:"network"`               // Name of the network
+	Height               int           `json:"height"`                // Height of the tip
+	Bits                 uint32        `json:"bits"`                  // Compact target of the next block
+	MempoolSize          int           `json:"mempool_size"`          // Number of pending transactions
+	Enabled              bool          `json:"enabled"`               // Whether the miner mines blocks continuously
+	Address              string        `json:"address"`               // Address receiving the block rewards
+	NTransactions        int           `json:"n_transactions"`        // Maximum number of transactions per block
+	TemplateTransactions int           `json:"template_transactions"` // Number of transactions of the last block template, besides the coinbase
+	TemplateFees         amount.Amount `json:"template_fees"`         // Total fees of the last block template
+	TemplateSize         int           `json:"template_size"`         // Size of the last block template in bytes
+	Threads              int           `json:"threads"`               // Number of proof of work workers
+	Hashrate             uint64        `json:"hashrate"`              // Hashes per second of the last proof of work search
+}
+
+// getBlockCount returns the height of the tip
+func (s *Server) getBlockCount(params []json.RawMessage) (interface{}, *Error) {
+	_, height := s.Blockchain.GetTip()
+	return height, nil
+}
+
+// getBlock returns a main chain block by hash or by height
+func (s *Server) getBlock(params []json.RawMessage) (interface{}, *Error) {
+	if len(params) != 1 {
+		return nil, invalidParams("expected a block hash or height")
+	}
+
+	// Look the block up by height or by hash, with the tip it is confirmed by
+	var cb *blockchain.ChainBlock
+	var height int
+	var blockID string
+	if err := json.Unmarshal(params[0], &height); err == nil {
+		cb = s.Blockchain.GetChainBlockByHeight(height)
+	} else if err := json.Unmarshal(params[0], &blockID); err == nil {
+		cb = s.Blockchain.GetChainBlockByID(blockID)
+	} else {
+		return nil, invalidParams("expected a block hash or height")
+	}
+	if cb == nil {
+		return nil, &Error{ERRINVALIDPARAMS, "block not found"}
+	}
+
+	return &BlockResult{
+		Height:        cb.Height,
+		Confirmations: cb.Confirmations(),
+		Block:         cb.Block,
+	}, nil
+}
+
+// getTransaction returns a pending or confirmed transaction
+func (s *Server) getTransaction(params []json.RawMessage) (interface{}, *Error) {
+	var txID string
+	if len(params) != 1 || json.Unmarshal(params[0], &txID) != nil {
+		return nil, invalidParams("expected a transaction ID")
+	}
+
+	// Look in the mempool first
+	if tx := s.Mempool.GetTransaction(txID); tx != nil {
+		return &TransactionResult{Transaction: tx}, nil
+	}
+
+	// Look in the main chain
+	tx, cb := s.Blockchain.GetTransaction(txID)
+	if tx == nil {
+		return nil, &Error{ERRINVALIDPARAMS, "transaction not found"}
+	}
+
+	return &TransactionResult{
+		Transaction:   tx,
+		BlockID:       cb.Block.BlockID,
+		Confirmations: cb.Confirmations(),
+	}, nil
+}
+
+// getBalance returns the confirmed balance of an address
+func (s *Server) getBalance(params []json.RawMessage) (interface{}, *Error) {
+	var address string
+	if len(params) != 1 || json.Unmarshal(params[0], &address) != nil {
+		return nil, invalidParams("expected an address")
+	}
+
+	return s.Blockchain.GetBalance(address), nil
+}
+
+// listUnspent returns the confirmed unspent outputs of an address that can
+// be spent in the next block
+func (s *Server) listUnspent(params []json.RawMessage) (interface{}, *Error) {
+	var address string
+	if len(params) != 1 || json.Unmarshal(params[0], &address) != nil {
+		return nil, invalidParams("expected an address")
+	}
+
+	return s.Blockchain.GetSpendableUTXOs(address), nil
+}
+
+// getMempool returns the IDs of the pending transactions
+func (s *Server) getMempool(params []json.RawMessage) (interface{}, *Error) {
+	transactions := s.Mempool.GetTransactions()
+
+	txIDs := make([]string, len(transactions))
+	for i, tx := range transactions {
+		txIDs[i] = tx.TransactionID
+	}
+	return txIDs, nil
+}
+
+// getMempoolInfo returns the size, the limits and the eviction counters of the mempool
+func (s *Server) getMempoolInfo(params []json.RawMessage) (interface{}, *Error) {
+	return s.Mempool.GetInfo(), nil
+}
+
+// sendRawTransaction validates a signed transaction, adds it to the mempool
+// and gossips it to the network
+func (s *Server) sendRawTransaction(params []json.RawMessage) (interface{}, *Error) {
+	var tx transaction.Transaction
+	if len(params) != 1 || json.Unmarshal(params[0], &tx) != nil {
+		return nil, invalidParams("expected a transaction")
+	}
+
+	// Validate the transaction
+	if err := s.Blockchain.ValidateTransaction(&tx); err != nil {
+		return nil, &Error{ERRINVALIDPARAMS, fmt.Sprintf("invalid transaction: %v", err)}
+	}
+
+	// Add the transaction to the pool
+	if err := s.Mempool.AddTransaction(&tx); err != nil {
+		return nil, &Error{ERRINVALIDPARAMS, fmt.Sprintf("rejected transaction: %v", err)}
+	}
+
+	// Gossip the transaction
+	msg, err := transaction.NewMessage(s.IPAddress, "", &tx)
+	if err != nil {
+		return nil, &Error{ERRINTERNAL, err.Error()}
+	}
+	s.GossipManager.Gossip(msg)
+
+	return tx.TransactionID, nil
+}
+
+// getPeerInfo returns the group members and the open peer connections
+func (s *Server) getPeerInfo(params []json.RawMessage) (interface{}, *Error) {
+	result := &PeerInfoResult{
+		Members: make([]*MemberInfo, 0),
+		Peers:   make([]*PeerInfo, 0),
+	}
+
+	// Collect the members
+	memberList := s.MembershipManager.MemberList
+	memberList.Mutex.RLock()
+	for _, member := range memberList.Members {
+		result.Members = append(result.Members, &MemberInfo{
+			Address:   member.IPAddress,
+			Heartbeat: member.Heartbeat,
+			Timestamp: member.Timestamp,
+		})
+	}
+	memberList.Mutex.RUnlock()
+
+	// Collect the peer connections
+	for _, peer := range s.MembershipManager.Transceiver.PeerManager.GetPeers() {
+		result.Peers = append(result.Peers, &PeerInfo{
+			Address:          peer.GetAddress(),
+			Inbound:          peer.Inbound,
+			ConnectedAt:      peer.ConnectedAt,
+			MessagesSent:     peer.MessagesSent.Load(),
+			MessagesReceived: peer.MessagesReceived.Load(),
+			Misbehavior:      peer.Misbehavior.Load(),
+		})
+	}
+
+	return result, nil
+}
+
+// getMiningInfo returns the state of the miner
+func (s *Server) getMiningInfo(params []json.RawMessage) (interface{}, *Error) {
+	_, height := s.Blockchain.GetTip()
+	info := &MiningInfo{
+		Network:       s.Blockchain.Params.Name,
+		Height:        height,
+		Bits:          s.Blockchain.CalculateBits(),
+		MempoolSize:   len(s.Mempool.GetTransactions()),
+		Enabled:       s.Miner.IsEnabled(),
+		Address:       s.Miner.GetAddress(),
+		NTransactions: s.Miner.NTransactions,
+		Threads:       s.Miner.GetThreads(),
+		Hashrate:      s.Miner.Hashrate(),
+	}
+
+	// Report the last block template
+	if template := s.Miner.LastTemplate(); template != nil {
+		info.TemplateTransactions = len(template.Block.Transactions) - 1
+		info.TemplateFees = template.Fees
+		info.TemplateSize = template.Size
+	}
+	return info, nil
+}
+
+// setMining enables or disables continuous mining, and returns the state of the miner
+func (s *Server) setMining(params []json.RawMessage) (interface{}, *Error) {
+	var enabled bool
+	if len(params) != 1 || json.Unmarshal(params[0], &enabled) != nil {
+		return nil, invalidParams("expected true or false")
+	}
+
+	s.Miner.SetEnabled(enabled)
+	return s.getMiningInfo(nil)
+}
+
+// setMiningThreads sets the number of proof of work workers, and returns the
+// state of the miner
+func (s *Server) setMiningThreads(params []json.RawMessage) (interface{}, *Error) {
+	var threads int
+	if len(params) != 1 || json.Unmarshal(params[0], &threads) != nil {
+		return nil, invalidParams("expected a number of threads")
+	}
+
+	if err := s.Miner.SetThreads(threads); err != nil {
+		return nil, invalidParams(err.Error())
+	}
+	return s.getMiningInfo(nil)
+}
+
+// setMiningAddress sets the address receiving the block rewards, and returns
+// the state of the miner
+func (s *Server) setMiningAddress(params []json.RawMessage) (interface{}, *Error) {
+	var address string
+	if len(params) != 1 || json.Unmarshal(params[0], &address) != nil {
+		return nil, invalidParams("expected an address")
+	}
+
+	if err := s.Miner.SetAddress(address); err != nil {
+		return nil, invalidParams(err.Error())
+	}
+	return s.getMiningInfo(nil)
+}
+
+// generate mines the given number of blocks (1 by default, at most
+// MAXGENERATE) right away, even if they are empty, and returns their IDs
+func (s *Server) generate(params []json.RawMessage) (interface{}, *Error) {
+	n := 1
+	if len(params) > 1 || (len(params) == 1 && json.Unmarshal(params[0], &n) != nil) || n < 1 || n > MAXGENERATE {
+		return nil, invalidParams(fmt.Sprintf("expected a number of blocks between 1 and %d", MAXGENERATE))
+	}
+
+	blockIDs, err := s.Miner.Generate(n)
+	if err != nil {
+		return nil, &Error{ERRINTERNAL, fmt.Sprintf("generated %d of %d blocks: %v", len(blockIDs), n, err)}
+	}
+	return blockIDs, nil
+}
+
+// getStratumInfo returns the connected miners, the current job and the share
+// accounting of the workers of the stratum server
+func (s *Server) getStratumInfo(params []json.RawMessage) (interface{}, *Error) {
+	if s.StratumServer == nil {
+		return nil, &Error{ERRINVALIDREQUEST, "the stratum server is disabled"}
+	}
+	return s.StratumServer.GetInfo(), nil
+}
+
+// auditSupply checks the issued supply against the subsidy schedule
+func (s *Server) auditSupply(params []json.RawMessage) (interface{}, *Error) {
+	audit, err := s.Blockchain.AuditSupply()
+	if err != nil {
+		return nil, &Error{ERRINTERNAL, fmt.Sprintf("supply audit failed: %v", err)}
+	}
+	return audit, nil
+}
+
+// invalidParams returns an invalid params error
+func invalidParams(msg string) *Error {
+	return &Error{ERRINVALIDPARAMS, msg}
+}
@@ -0,0 +1,194 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/mining"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/mining/mempool"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/mining/stratum"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/p2p/gossip"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/p2p/membership"
+)
+
+const (
+	JSONRPCVERSION  = "2.0"
+	MAXREQUESTSIZE  = 4 << 20 // Upper bound on the size of a request body
+	MAXGENERATE     = 1000    // Maximum number of blocks mined by a generate call
+	SHUTDOWNTIMEOUT = 5 * time.Second
+)
+
+// Standard JSON-RPC error codes
+const (
+	ERRPARSE          = -32700
+	ERRINVALIDREQUEST = -32600
+	ERRMETHODNOTFOUND = -32601
+	ERRINVALIDPARAMS  = -32602
+	ERRINTERNAL       = -32603
+)
+
+type Request struct {
+	JSONRPC string            `json:"jsonrpc"` // Protocol version, always "2.0"
+	Method  string            `json:"method"`  // Name of the called method
+	Params  []json.RawMessage `json:"params"`  // Positional parameters
+	ID      json.RawMessage   `json:"id"`      // Request ID echoed in the response
+}
+
+type Response struct {
+	JSONRPC string          `json:"jsonrpc"`          // Protocol version, always "2.0"
+	Result  interface{}     `json:"result,omitempty"` // Result of a successful call
+	Error   *Error          `json:"error,omitempty"`  // Error of a failed call
+	ID      json.RawMessage `json:"id"`               // ID of the request
+}
+
+type Error struct {
+	Code    int    `json:"code"`    // Error code
+	Message string `json:"message"` // Error description
+}
+
+// Error implements the error interface
+func (e *Error) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+type handler func(params []json.RawMessage) (interface{}, *Error)
+
+type Server struct {
+	Address           string                        // Address the HTTP server listens on
+	IPAddress         string                        // P2P address of the node
+	Blockchain        *blockchain.Blockchain        // Blockchain reference
+	Mempool           *mempool.Mempool              // Mempool reference
+	MembershipManager *membership.MembershipManager // Membership manager reference
+	GossipManager     *gossip.GossipManager         // Gossip manager reference
+	Miner             *mining.Miner                 // Miner reference
+	StratumServer     *stratum.Server               // Stratum server reference, or nil if disabled
+	Listener          net.Listener                  // Listener bound to Address (nil until Listen)
+	httpServer        *http.Server                  // Underlying HTTP server
+	handlers          map[string]handler            // Method name -> Handler
+}
+
+// NewServer creates a new JSON-RPC server
+func NewServer(
+	address string,
+	IPAddress string,
+	blockchain *blockchain.Blockchain,
+	mempool *mempool.Mempool,
+	membershipManager *membership.MembershipManager,
+	gossipManager *gossip.GossipManager,
+	miner *mining.Miner,
+	stratumServer *stratum.Server,
+) *Server {
+	s := &Server{
+		Address:           address,
+		IPAddress:         IPAddress,
+		Blockchain:        blockchain,
+		Mempool:           mempool,
+		MembershipManager: membershipManager,
+		GossipManager:     gossipManager,
+		Miner:             miner,
+		StratumServer:     stratumServer,
+	}
+
+	s.handlers = map[string]handler{
+		"getblockcount":      s.getBlockCount,
+		"getblock":           s.getBlock,
+		"gettransaction":     s.getTransaction,
+		"getbalance":         s.getBalance,
+		"listunspent":        s.listUnspent,
+		"getmempool":         s.getMempool,
+		"getmempoolinfo":     s.getMempoolInfo,
+		"sendrawtransaction": s.sendRawTransaction,
+		"getpeerinfo":        s.getPeerInfo,
+		"getmininginfo":      s.getMiningInfo,
+		"auditsupply":        s.auditSupply,
+		"setmining":          s.setMining,
+		"setminingthreads":   s.setMiningThreads,
+		"setminingaddress":   s.setMiningAddress,
+		"generate":           s.generate,
+		"getstratuminfo":     s.getStratumInfo,
+	}
+
+	s.httpServer = &http.Server{
+		Addr:    address,
+		Handler: http.HandlerFunc(s.ServeHTTP),
+	}
+	return s
+}
+
+// Listen binds the address of the server
+func (s *Server) Listen() error {
+	listener, err := net.Listen("tcp", s.Address)
+	if err != nil {
+		return fmt.Errorf("failed to start RPC server on %s: %v", s.Address, err)
+	}
+
+	s.Listener = listener
+	return nil
+}
+
+// Run serves JSON-RPC requests on the listener
+func (s *Server) Run() {
+	log.Printf("RPC server listening on %s\n", s.Address)
+	if err := s.httpServer.Serve(s.Listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("RPC server stopped: %v\n", err)
+	}
+}
+
+// ServeHTTP handles a single JSON-RPC request
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "JSON-RPC requests must use POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Decode the request
+	var req Request
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, MAXREQUESTSIZE))
+	if err := decoder.Decode(&req); err != nil {
+		s.writeResponse(w, &Response{JSONRPC: JSONRPCVERSION, Error: &Error{ERRPARSE, err.Error()}})
+		return
+	}
+	if req.JSONRPC != JSONRPCVERSION || req.Method == "" {
+		s.writeResponse(w, &Response{JSONRPC: JSONRPCVERSION, Error: &Error{ERRINVALIDREQUEST, "invalid request"}, ID: req.ID})
+		return
+	}
+
+	// Dispatch the request
+	resp := &Response{JSONRPC: JSONRPCVERSION, ID: req.ID}
+	h, ok := s.handlers[req.Method]
+	if !ok {
+		resp.Error = &Error{ERRMETHODNOTFOUND, "method not found: " + req.Method}
+	} else {
+		resp.Result, resp.Error = h(req.Params)
+	}
+
+	s.writeResponse(w, resp)
+}
+
+// writeResponse writes a JSON-RPC response
+func (s *Server) writeResponse(w http.ResponseWriter, resp *Response) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("Failed to write RPC response: %v\n", err)
+	}
+}
+
+// Close stops the RPC server
+func (s *Server) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), SHUTDOWNTIMEOUT)
+	defer cancel()
+
+	s.httpServer.Shutdown(ctx)
+
+	// Release the port if the server never ran
+	if s.Listener != nil {
+		s.Listener.Close()
+	}
+}
@@ -9,7 +9,12 @@ import (
 	"math/big"
 )
 
-// VerifySignature checks if the given signature is valid for the given data
+// halfOrder is half the order of the curve, the highest S of a signature
+var halfOrder = new(big.Int).Rsh(elliptic.P256().Params().N, 1)
+
+// VerifySignature checks if the given signature is valid for the given data.
+// The signature must be exactly R and S padded to 32 bytes each, with a low
+// S, so that a valid signature cannot be altered into another valid one.
 func VerifySignature(publicKey, data, signature string) error {
 	pubKeyBytes, err := hex.DecodeString(publicKey)
 	if err != nil || len(pubKeyBytes) < 64 {
@@ -17,7 +22,7 @@ func VerifySignature(publicKey, data, signature string) error {
 	}
 
 	signatureBytes, err := hex.DecodeString(signature)
-	if err != nil || len(signatureBytes) < 64 {
+	if err != nil || len(signatureBytes) != 64 {
 		return fmt.Errorf("invalid signature")
 	}
 
@@ -28,6 +33,9 @@ func VerifySignature(publicKey, data, signature string) error {
 	// Extract R and S values for the signature
 	r := new(big.Int).SetBytes(signatureBytes[:32])
 	s := new(big.Int).SetBytes(signatureBytes[32:])
+	if s.Cmp(halfOrder) > 0 {
+		return fmt.Errorf("invalid signature: high S")
+	}
 
 	// Hash the message
 	hash := sha256.Sum256([]byte(data))
@@ -40,3 +48,18 @@ func VerifySignature(publicKey, data, signature string) error {
 		return fmt.Errorf("invalid signature")
 	}
 }
+
+// ValidateAddress checks that the address is a hex-encoded public key on the curve
+func ValidateAddress(address string) error {
+	pubKeyBytes, err := hex.DecodeString(address)
+	if err != nil || len(pubKeyBytes) != 64 {
+		return fmt.Errorf("invalid address: %s", address)
+	}
+
+	x := new(big.Int).SetBytes(pubKeyBytes[:32])
+	y := new(big.Int).SetBytes(pubKeyBytes[32:])
+	if !elliptic.P256().IsOnCurve(x, y) {
+		return fmt.Errorf("invalid address: %s", address)
+	}
+	return nil
+}
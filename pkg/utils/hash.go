@@ -26,3 +26,12 @@ func SerializeHashes(hashes []string) ([]byte, error) {
 	}
 	return data, nil
 }
+
+// DeserializeHashes deserializes a JSON array into a slice of hashes
+func DeserializeHashes(data []byte) ([]string, error) {
+	var hashes []string
+	if err := json.Unmarshal(data, &hashes); err != nil {
+		return nil, err
+	}
+	return hashes, nil
+}
@@ -3,42 +3,163 @@ package wallet
 import (
 	"fmt"
 
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/amount"
 	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/transaction"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/blockchain/utxo"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/rpc"
+	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/utils"
 )
 
-// CreateTransaction creates a new transaction
-func (w *Wallet) CreateTransaction(recipient string, amount float64, fee float64) (*transaction.Transaction, error) {
+// CreateTransaction creates a new transaction spending the given UTXOs
+func (w *Wallet) CreateTransaction(utxos []*utxo.UTXO, recipient string, value amount.Amount, fee amount.Amount) (*transaction.Transaction, error) {
+	// Nodes reject outputs locked to anything but a public key
+	if err := utils.ValidateAddress(recipient); err != nil {
+		return nil, fmt.Errorf("invalid recipient: %v", err)
+	}
+
+	// Select the inputs covering the value and the fee
+	target, err := value.Add(fee)
+	if err != nil {
+		return nil, err
+	}
+	inputs, totalInput, err := selectInputs(utxos, target)
+	if err != nil {
+		return nil, err
+	}
+
+	// Pay the recipient and return the change to the wallet
+	outputs := []*transaction.TxOutput{transaction.NewTxOutput(value, recipient)}
+	change, err := totalInput.Sub(target)
+	if err != nil {
+		return nil, err
+	}
+	if change > 0 {
+		outputs = append(outputs, transaction.NewTxOutput(change, w.GetAddress()))
+	}
+
 	// Create the transaction
-	tx := transaction.NewUnsignedTransaction(w.GetAddress(), recipient, amount, fee)
+	tx := transaction.NewUnsignedTransaction(w.GetAddress(), inputs, outputs, fee)
+	if err := w.signTransaction(tx); err != nil {
+		return nil, err
+	}
 
+	return tx, nil
+}
+
+// BumpFee creates a replacement of a pending transaction of the wallet that
+// spends the same inputs and pays a higher fee, taken from the change output
+func (w *Wallet) BumpFee(tx *transaction.Transaction, fee amount.Amount) (*transaction.Transaction, error) {
+	if tx.Sender != w.GetAddress() {
+		return nil, fmt.Errorf("transaction %s was not sent by the wallet", tx.TransactionID)
+	}
+	if fee <= tx.Fee {
+		return nil, fmt.Errorf("new fee %s must exceed the current fee %s", fee, tx.Fee)
+	}
+	increase, err := fee.Sub(tx.Fee)
+	if err != nil {
+		return nil, err
+	}
+
+	// Copy the inputs and the outputs
+	inputs := make([]*transaction.TxInput, len(tx.Inputs))
+	for i, input := range tx.Inputs {
+		inputs[i] = transaction.NewTxInput(input.TxID, input.OutputIndex)
+	}
+	outputs := make([]*transaction.TxOutput, len(tx.Outputs))
+	for i, output := range tx.Outputs {
+		outputs[i] = transaction.NewTxOutput(output.Value, output.Address)
+	}
+
+	// Take the fee increase from the change, the last output paying the
+	// wallet, and drop the change if nothing is left
+	change := -1
+	for i, output := range outputs {
+		if output.Address == w.GetAddress() {
+			change = i
+		}
+	}
+	if change < 0 || outputs[change].Value < increase {
+		return nil, fmt.Errorf("the change of transaction %s cannot cover a fee increase of %s", tx.TransactionID, increase)
+	}
+	outputs[change].Value -= increase
+	if outputs[change].Value == 0 {
+		outputs = append(outputs[:change], outputs[change+1:]...)
+	}
+
+	// Create the replacement
+	replacement := transaction.NewUnsignedTransaction(w.GetAddress(), inputs, outputs, fee)
+	if err := w.signTransaction(replacement); err != nil {
+		return nil, err
+	}
+
+	return replacement, nil
+}
+
+// signTransaction signs a transaction and generates its ID
+func (w *Wallet) signTransaction(tx *transaction.Transaction) error {
 	// Sign the transaction
 	hash := tx.Hash()
 	signature, err := w.Sign(hash)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	tx.Signature = signature
 
 	// Generate the transaction ID
 	tx.TransactionID = tx.GenerateTransactionID()
 
-	return tx, nil
+	return nil
 }
 
-// SendTransaction sends a transaction to a node in the network
-func (w *Wallet) SendTransaction(tx *transaction.Transaction, selfAddress string, nodeAddress string) error {
-	// Create and serialize the mwssage
-	message, err := transaction.NewMessage(
-		selfAddress,
-		nodeAddress,
-		tx,
-	)
-	if err != nil {
-		return fmt.Errorf("failed to create transaction message: %v", err)
+// selectInputs selects UTXOs until their total value covers the target
+func selectInputs(utxos []*utxo.UTXO, target amount.Amount) ([]*transaction.TxInput, amount.Amount, error) {
+	inputs := make([]*transaction.TxInput, 0)
+	total := amount.Amount(0)
+	for _, u := range utxos {
+		if total >= target {
+			break
+		}
+		inputs = append(inputs, transaction.NewTxInput(u.OutPoint.TxID, u.OutPoint.Index))
+
+		var err error
+		if total, err = total.Add(u.Output.Value); err != nil {
+			return nil, 0, err
+		}
 	}
 
-	// Send the message to the node
-	w.Transmitter.SendMessage(message)
+	if total < target {
+		return nil, 0, fmt.Errorf("insufficient balance: %s", total)
+	}
 
+	return inputs, total, nil
+}
+
+// FetchUTXOs requests the wallet's unspent outputs from a node over JSON-RPC
+func (w *Wallet) FetchUTXOs(client *rpc.Client) ([]*utxo.UTXO, error) {
+	var utxos []*utxo.UTXO
+	if err := client.Call("listunspent", []interface{}{w.GetAddress()}, &utxos); err != nil {
+		return nil, fmt.Errorf("failed to fetch UTXOs: %v", err)
+	}
+	return utxos, nil
+}
+
+// FetchPendingTransaction requests a pending transaction from a node over JSON-RPC
+func (w *Wallet) FetchPendingTransaction(client *rpc.Client, txID string) (*transaction.Transaction, error) {
+	var result rpc.TransactionResult
+	if err := client.Call("gettransaction", []interface{}{txID}, &result); err != nil {
+		return nil, fmt.Errorf("failed to fetch transaction: %v", err)
+	}
+	if result.Confirmations > 0 {
+		return nil, fmt.Errorf("transaction %s is already confirmed", txID)
+	}
+	return result.Transaction, nil
+}
+
+// SendTransaction submits a transaction to a node over JSON-RPC
+func (w *Wallet) SendTransaction(client *rpc.Client, tx *transaction.Transaction) error {
+	var txID string
+	if err := client.Call("sendrawtransaction", []interface{}{tx}, &txID); err != nil {
+		return fmt.Errorf("failed to send transaction: %v", err)
+	}
 	return nil
 }
@@ -5,24 +5,21 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"encoding/hex"
-
-	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/message"
-	"github.com/CHIHCHIEH-LAI/simplified-bitcoin/pkg/network"
+	"math/big"
 )
 
 type Wallet struct {
-	PrivateKey  *ecdsa.PrivateKey `json:"-"`
-	PublicKey   []byte            `json:"public_key"`
-	Transmitter *network.Transmitter
+	PrivateKey *ecdsa.PrivateKey `json:"-"`
+	PublicKey  []byte            `json:"public_key"`
 }
 
 // NewWallet creates and returns a Wallet
 func NewWallet() *Wallet {
 	privateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
-	publicKey := append(privateKey.PublicKey.X.Bytes(), privateKey.PublicKey.Y.Bytes()...)
-	messageChannel := make(chan *message.Message)
-	transmitter := network.NewTransmitter(messageChannel)
-	return &Wallet{privateKey, publicKey, transmitter}
+	publicKey := make([]byte, 64)
+	privateKey.PublicKey.X.FillBytes(publicKey[:32])
+	privateKey.PublicKey.Y.FillBytes(publicKey[32:])
+	return &Wallet{privateKey, publicKey}
 }
 
 // GetAddress generates a public key hash (address) for the wallet
@@ -42,6 +39,16 @@ func (w *Wallet) Sign(hash string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	signature := append(r.Bytes(), s.Bytes()...)
+
+	// Use the low S, the only one VerifySignature accepts
+	n := elliptic.P256().Params().N
+	if s.Cmp(new(big.Int).Rsh(n, 1)) > 0 {
+		s.Sub(n, s)
+	}
+
+	// Pad R and S to 32 bytes each so the signature can be split when verified
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
 	return hex.EncodeToString(signature), nil
 }